@@ -0,0 +1,220 @@
+// Program bench.go compares Qry performance of pre-parsed record evaluation (kvf/rec.go recEval)
+// against the previous approach, where every field value was retrieved with fastjson.GetString/GetInt,
+// reparsing the whole record once per find condition and once per sort comparison.
+// A temporary db is loaded with records shaped like core.Location (same as loader.go test data).
+// The server program is not used, kvf.Qry is called directly inside a read transaction.
+//
+//	go run ./bench -n 85000 -runs 5
+//
+// Benchmarks of the current Qry paths (find, sort, top N, parallel) are in kvf/bench_test.go:
+//
+//	go test ./kvf -run none -bench Qry
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"kvfun/core"
+	"kvfun/kvf"
+
+	"github.com/valyala/fastjson"
+	bolt "go.etcd.io/bbolt"
+)
+
+var states = []string{"PA", "TX", "NY", "OH", "CA", "FL", "NJ", "GA"}
+var cities = []string{"Lakewood", "Westfield", "Springfield", "Lake City", "Franklin", "Bristol", "Clinton", "Madison"}
+
+type benchCase struct {
+	name string
+	req  kvf.QryRequest
+}
+
+var benchCases = []benchCase{
+	{"find st", kvf.QryRequest{
+		FindConditions: core.FindStr("st", kvf.Matches, "PA"),
+	}},
+	{"find 3 conditions", kvf.QryRequest{
+		FindConditions: []kvf.FindCondition{
+			{Fld: "st", Op: kvf.Matches, ValStr: "PA"},
+			{Fld: "locationType", Op: kvf.GreaterThan, ValInt: 1},
+			{Fld: "address", Op: kvf.Contains, ValStr: "west"},
+		},
+	}},
+	{"find + sort 2 flds", kvf.QryRequest{
+		FindConditions: core.FindInt("locationType", kvf.GreaterThan, 1),
+		SortFlds: []kvf.SortKey{
+			{Fld: "locationType", Dir: kvf.DescInt},
+			{Fld: "city", Dir: kvf.AscStr},
+		},
+	}},
+	{"sort all 2 flds", kvf.QryRequest{
+		SortFlds: []kvf.SortKey{
+			{Fld: "city", Dir: kvf.AscStr},
+			{Fld: "address", Dir: kvf.AscStr},
+		},
+	}},
+	{"top 20 lastActionDt", kvf.QryRequest{
+		SortFlds: core.SortBy("lastActionDt", kvf.DescStr),
+		Limit:    20,
+	}},
+}
+
+func main() {
+	recCnt := flag.Int("n", 85000, "number of records loaded into temporary db")
+	runs := flag.Int("runs", 5, "number of times each case is run, average is reported")
+	flag.Parse()
+
+	dir, err := os.MkdirTemp("", "kvfbench")
+	if err != nil {
+		log.Fatalln("create temp dir failed", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(filepath.Join(dir, "bench.db"), 0600, &bolt.Options{NoSync: true})
+	if err != nil {
+		log.Fatalln("open db failed", err)
+	}
+	defer db.Close()
+
+	loadRecs(db, *recCnt)
+
+	fmt.Printf("%d records, %d runs per case\n", *recCnt, *runs)
+	fmt.Printf("%-22s %8s %14s %14s %8s\n", "case", "recs", "reparse", "pre-parsed", "speedup")
+
+	for _, bc := range benchCases {
+		req := bc.req
+		req.BktName = "location"
+		var cnt int
+		old := timeRuns(db, *runs, func(tx *bolt.Tx) {
+			cnt = len(qryReparse(tx, &req))
+		})
+		cur := timeRuns(db, *runs, func(tx *bolt.Tx) {
+			resp := kvf.Qry(context.Background(), tx, &req)
+			if len(resp.Recs) != cnt {
+				fmt.Println("RESULT COUNT MISMATCH", bc.name, cnt, len(resp.Recs))
+			}
+		})
+		fmt.Printf("%-22s %8d %14s %14s %7.1fx\n", bc.name, cnt, old, cur, float64(old)/float64(cur))
+	}
+}
+
+func timeRuns(db *bolt.DB, runs int, fn func(tx *bolt.Tx)) time.Duration {
+	var total time.Duration
+	for i := 0; i < runs; i++ {
+		db.View(func(tx *bolt.Tx) error {
+			start := time.Now()
+			fn(tx)
+			total += time.Since(start)
+			return nil
+		})
+	}
+	return (total / time.Duration(runs)).Round(time.Microsecond)
+}
+
+func loadRecs(db *bolt.DB, recCnt int) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket([]byte("location"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < recCnt; i++ {
+			rec := core.Location{
+				Id:           fmt.Sprintf("5b%022x", i*7919),
+				Address:      fmt.Sprintf("%d %s Ave", 100+i%900, []string{"West", "North", "Main", "Oak"}[i%4]),
+				City:         cities[(i/3)%len(cities)],
+				St:           states[i%len(states)],
+				Zip:          fmt.Sprintf("%05d", 10000+i%89999),
+				LocationType: 1 + i%3,
+				LastActionDt: fmt.Sprintf("202%d-%02d-%02d", 1+i%3, 1+i%12, 1+i%28),
+				Notes:        []string{"Note #1", "Note #2"},
+			}
+			jsonRec, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			if err := bkt.Put([]byte(rec.Id), jsonRec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalln("load recs failed", err)
+	}
+}
+
+// Func qryReparse is the previous Qry implementation, kept here as the benchmark baseline.
+// Each field value is retrieved with fastjson.GetString/GetInt, which parses the entire record.
+func qryReparse(tx *bolt.Tx, req *kvf.QryRequest) [][]byte {
+	csr := tx.Bucket([]byte(req.BktName)).Cursor()
+	result := make(map[string][]byte)
+	keys := make([]string, 0)
+	for k, v := csr.First(); k != nil; k, v = csr.Next() {
+		if req.FindConditions == nil || findReparse(v, req.FindConditions) {
+			key := string(k)
+			result[key] = v
+			keys = append(keys, key)
+		}
+	}
+	if req.SortFlds != nil {
+		slices.SortFunc(keys, func(a, b string) int {
+			reca, recb := result[a], result[b]
+			for _, sortkey := range req.SortFlds {
+				var n int
+				switch sortkey.Dir {
+				case kvf.AscStr, kvf.DescStr:
+					n = cmp.Compare(strings.ToLower(fastjson.GetString(reca, sortkey.Fld)), strings.ToLower(fastjson.GetString(recb, sortkey.Fld)))
+				case kvf.AscInt, kvf.DescInt:
+					n = cmp.Compare(fastjson.GetInt(reca, sortkey.Fld), fastjson.GetInt(recb, sortkey.Fld))
+				}
+				if n == 0 {
+					continue
+				}
+				if sortkey.Dir == kvf.DescStr || sortkey.Dir == kvf.DescInt {
+					n = -n
+				}
+				return n
+			}
+			return 0
+		})
+	}
+	if req.Limit > 0 && len(keys) > req.Limit {
+		keys = keys[:req.Limit]
+	}
+	recs := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		recs = append(recs, slices.Clone(result[key]))
+	}
+	return recs
+}
+
+func findReparse(rec []byte, conditions []kvf.FindCondition) bool {
+	for _, condition := range conditions {
+		var met bool
+		switch condition.Op {
+		case kvf.Matches, kvf.Contains:
+			val := strings.ToLower(fastjson.GetString(rec, condition.Fld))
+			compareVal := strings.ToLower(condition.ValStr)
+			met = val == compareVal || (condition.Op == kvf.Contains && strings.Contains(val, compareVal))
+		case kvf.GreaterThan:
+			met = fastjson.GetInt(rec, condition.Fld) > condition.ValInt
+		default:
+			log.Fatalln("op not supported by benchmark baseline", condition.Op)
+		}
+		if !met {
+			return false
+		}
+	}
+	return true
+}
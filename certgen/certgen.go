@@ -0,0 +1,44 @@
+// Program certgen.go generates self-signed certificates for testing the server with tls and mutual tls.
+// A CA is created and used to sign a server certificate and a client certificate (see certs/certs.go).
+// Not for production use, keys are written unencrypted.
+//
+//	go run ./certgen -dir certs -hosts localhost,127.0.0.1 -client client1
+//
+// Files written to dir:
+//	ca.pem, ca-key.pem         - CA, use for server tls.clientCAFile and kvf.NewTLSClient caFile
+//	server.pem, server-key.pem - use for server tls.certFile and tls.keyFile
+//	client.pem, client-key.pem - use for kvf.NewTLSClient certFile and keyFile
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"kvfun/certgen/certs"
+)
+
+func main() {
+	dir := flag.String("dir", "certs", "output directory")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "comma separated server host names and ip addresses")
+	client := flag.String("client", "kvf-client", "client certificate common name")
+	validFor := flag.Duration("valid", 365*24*time.Hour, "certificate validity period")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		log.Fatalln("create dir failed", err)
+	}
+	set, err := certs.Generate(strings.Split(*hosts, ","), *client, *validFor)
+	if err != nil {
+		log.Fatalln("generate certificates failed", err)
+	}
+	for name, cert := range map[string]*certs.Cert{"ca": set.CA, "server": set.Server, "client": set.Client} {
+		if err := cert.Write(*dir, name); err != nil {
+			log.Fatalln("write failed", name, err)
+		}
+	}
+	log.Println("certificates written to", *dir)
+}
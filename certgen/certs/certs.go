@@ -0,0 +1,139 @@
+// File certs.go generates a self-signed CA, and a server certificate and client certificate signed by it.
+// Used by certgen.go to write test certificates, and by the server tls tests to create them in memory.
+// Not for production use, keys are written unencrypted.
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Cert is a certificate and its private key.
+type Cert struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+// Set is the CA and the certificates it signed.
+type Set struct {
+	CA     *Cert
+	Server *Cert
+	Client *Cert
+}
+
+// Func Generate creates the CA, a server certificate for hosts (names and ip addresses) and a client certificate
+// with common name client, all valid for validFor.
+func Generate(hosts []string, client string, validFor time.Duration) (*Set, error) {
+	caTmpl, err := newTemplate("kvf test CA", validFor)
+	if err != nil {
+		return nil, err
+	}
+	caTmpl.IsCA = true
+	caTmpl.BasicConstraintsValid = true
+	caTmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	set := new(Set)
+	if set.CA, err = createCert(caTmpl, nil); err != nil {
+		return nil, err
+	}
+
+	serverTmpl, err := newTemplate("kvf server", validFor)
+	if err != nil {
+		return nil, err
+	}
+	serverTmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	serverTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if ip := net.ParseIP(host); ip != nil {
+			serverTmpl.IPAddresses = append(serverTmpl.IPAddresses, ip)
+		} else if host != "" {
+			serverTmpl.DNSNames = append(serverTmpl.DNSNames, host)
+		}
+	}
+	if set.Server, err = createCert(serverTmpl, set.CA); err != nil {
+		return nil, err
+	}
+
+	clientTmpl, err := newTemplate(client, validFor)
+	if err != nil {
+		return nil, err
+	}
+	clientTmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	clientTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if set.Client, err = createCert(clientTmpl, set.CA); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func newTemplate(commonName string, validFor time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("serial number: %w", err)
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"kvfun"}},
+		NotBefore:    time.Now().Add(-time.Hour), // allow for clock differences
+		NotAfter:     time.Now().Add(validFor),
+	}, nil
+}
+
+// Func createCert creates a new key and certificate, signed by parent (self-signed if parent is nil).
+func createCert(tmpl *x509.Certificate, parent *Cert) (*Cert, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.Cert, parent.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate %s: %w", tmpl.Subject.CommonName, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	return &Cert{Cert: cert, Key: key}, nil
+}
+
+// Func CertPEM returns the certificate pem encoded.
+func (c *Cert) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// Func KeyPEM returns the private key pem encoded.
+func (c *Cert) KeyPEM() ([]byte, error) {
+	keyDER, err := x509.MarshalECPrivateKey(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// Func Write saves the certificate as dir/name.pem and the key as dir/name-key.pem.
+func (c *Cert) Write(dir, name string) error {
+	keyPEM, err := c.KeyPEM()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), c.CertPEM(), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600)
+}
@@ -54,6 +54,7 @@ func main() {
 	httpClient = new(http.Client)
 
 	//kvf.BaseURL = "http://localhost:8000/"  // to override default located in kvf/run.go - where server.go pgm is listening
+	//kvf.APIKey = "change-me-admin-key-0001" // required if server config lists apiKeys, see server/auth.go
 
 	put1() // add a single record
 
@@ -78,7 +79,12 @@ func main() {
 
 	qry3() // qry using using various options
 
-	kvf.Run(httpClient, "close", "close db") // normally client won't close db, this just demonstrates how it works
+	qryCount() // count and exists qrys, no recs are returned
+
+	qryStream() // recs are streamed as NDJSON, 1 rec per line, rather than in Response.Recs
+
+	// normally client won't shut down the server, use kvf.Shutdown(httpClient, adminToken) if needed
+	// server closes db after in-flight requests finish, see server/shutdown.go
 }
 
 func put1() {
@@ -265,13 +271,50 @@ func qry3() {
 	}
 }
 
-func checkResp(resp *kvf.Response, err error) bool {
+func qryCount() {
+	log.Println("-- qryCount: count of st=PA, exists city=Howdy --")
+
+	find := core.FindStr("st", kvf.Matches, "PA")
+	cnt, err := core.Count(httpClient, bktLocation, find) // server does not build Response.Recs
 	if err != nil {
 		panic(err)
 	}
+	log.Println("count st=PA", cnt)
+
+	find = core.FindStr("city", kvf.Matches, "Howdy")
+	found, err := core.Exists(httpClient, bktLocation, find) // server stops scan at 1st match
+	if err != nil {
+		panic(err)
+	}
+	log.Println("exists city=Howdy", found, "(should be false, test recs were deleted)")
+
+	req := kvf.GetAllRequest{BktName: bktLocation, ResultMode: kvf.ResultCount} // ResultMode also works for GetAll
+	resp, err := kvf.Run(httpClient, "getall", req)
+	checkResp(resp, err)
+	log.Println("getAll count", resp.Count)
+}
+
+func qryStream() {
+	log.Println("-- qryStream: find st=PA, recs streamed as the server cursor advances --")
+	req := kvf.QryRequest{
+		BktName:        bktLocation,
+		FindConditions: core.FindStr("st", kvf.Matches, "PA"),
+	}
+	var locRec core.Location
+	resp, err := kvf.RunStream(httpClient, "qry", &req, func(rec []byte) error { // func is called for each rec
+		return json.Unmarshal(rec, &locRec)
+	})
+	checkResp(resp, err)
+	log.Println("streamed count", resp.Count, "last rec", locRec)
+}
+
+func checkResp(resp *kvf.Response, err error) bool {
+	if resp == nil { // request did not get a Response (connection or decode failure)
+		panic(err)
+	}
 	if resp.Status == kvf.Ok {
 		return true
 	}
-	log.Println(kvf.StatusTxt[resp.Status], resp.Msg) // StatusTxt map in kvf/handlers.go
+	log.Println(kvf.StatusTxt[resp.Status], resp.Code, resp.Msg) // StatusTxt map in kvf/handlers.go
 	return false
 }
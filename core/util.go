@@ -98,6 +98,47 @@ func Qry(httpClient *http.Client, bktName string, findConditions []kvf.FindCondi
 	return resp, err
 }
 
+// Count provides shorthand way of calling kvf.Run with Qry request using ResultMode "count".
+// Returns number of records meeting findConditions, Response.Recs is not loaded by server.
+// startEndKeys work same as Qry func above.
+func Count(httpClient *http.Client, bktName string, findConditions []kvf.FindCondition, startEndKeys ...string) (int, error) {
+	resp, err := qryMode(httpClient, bktName, findConditions, kvf.ResultCount, startEndKeys)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+// Exists provides shorthand way of calling kvf.Run with Qry request using ResultMode "exists".
+// Returns true if any record meets findConditions. Server stops scanning at first match.
+// startEndKeys work same as Qry func above.
+func Exists(httpClient *http.Client, bktName string, findConditions []kvf.FindCondition, startEndKeys ...string) (bool, error) {
+	resp, err := qryMode(httpClient, bktName, findConditions, kvf.ResultExists, startEndKeys)
+	if err != nil {
+		return false, err
+	}
+	return resp.Exists, nil
+}
+
+func qryMode(httpClient *http.Client, bktName string, findConditions []kvf.FindCondition, mode string, startEndKeys []string) (*kvf.Response, error) {
+	req := kvf.QryRequest{
+		BktName:        bktName,
+		FindConditions: findConditions,
+		ResultMode:     mode,
+	}
+	if len(startEndKeys) > 0 {
+		req.StartKey = startEndKeys[0]
+	}
+	if len(startEndKeys) > 1 {
+		req.EndKey = startEndKeys[1]
+	}
+	resp, err := kvf.Run(httpClient, "qry", &req)
+	if err == nil {
+		err = resp.Err() // Warning is also an error here, Fail error is returned by kvf.Run
+	}
+	return resp, err
+}
+
 // FindStr creates []kvf.FindCondition with 1 str condition loaded
 func FindStr(fld string, op int, val string) []kvf.FindCondition {
 	findConditions := make([]kvf.FindCondition, 0, 5)
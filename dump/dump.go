@@ -0,0 +1,148 @@
+// Program dump.go exports bkts from the server as NDJSON (see kvf/export.go) and imports them again,
+// ex. to copy bkts between servers or load them into other tools. Files ending in .gz are gzip compressed.
+//
+//	go run ./dump export -bkt location,state -o locations.ndjson.gz
+//	go run ./dump export -start k0100 -end k0199 -o part.ndjson
+//	go run ./dump import -i locations.ndjson.gz -mode replace
+//	go run ./dump import -i locations.ndjson.gz -bkt location -start k0100 -end k0199
+//
+// -url sets the server address (default kvf.BaseURL), -key or env KVF_API_KEY sets the api key.
+
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kvfun/kvf"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	url := fs.String("url", kvf.BaseURL, "server address")
+	key := fs.String("key", os.Getenv("KVF_API_KEY"), "api key, default env KVF_API_KEY")
+	bkts := fs.String("bkt", "", "comma separated bkt names, default all bkts")
+	start := fs.String("start", "", "first key of the key range")
+	end := fs.String("end", "", "last key of the key range")
+
+	var err error
+	switch cmd {
+	case "export":
+		out := fs.String("o", "", "output file, .gz is gzip compressed, default stdout")
+		fs.Parse(args)
+		setClient(*url, *key)
+		err = export(kvf.ExportRequest{BktNames: split(*bkts), StartKey: *start, EndKey: *end}, *out)
+	case "import":
+		in := fs.String("i", "", "export file to import, .gz is gzip compressed")
+		mode := fs.String("mode", kvf.ImportMerge, "merge or replace")
+		fs.Parse(args)
+		if *in == "" {
+			fs.Usage()
+			os.Exit(2)
+		}
+		setClient(*url, *key)
+		err = importFile(kvf.ImportRequest{BktNames: split(*bkts), Mode: *mode, StartKey: *start, EndKey: *end}, *in)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatalln(cmd, "failed:", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dump export [-bkt a,b] [-start k] [-end k] [-o file]")
+	fmt.Fprintln(os.Stderr, "       dump import -i file [-mode merge|replace] [-bkt a,b] [-start k] [-end k]")
+	os.Exit(2)
+}
+
+func setClient(url, key string) {
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	kvf.BaseURL = url
+	kvf.APIKey = key
+}
+
+// Func export writes the export to path, written to a temp file and renamed so a failed export
+// does not leave a partial file under path.
+func export(req kvf.ExportRequest, path string) error {
+	httpClient := &http.Client{}
+	start := time.Now()
+	if path == "" {
+		resp, err := kvf.RunExport(httpClient, req, os.Stdout)
+		if err == nil {
+			log.Println("exported", resp.Count, "recs in", time.Since(start))
+		}
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after rename
+	var w io.Writer = tmp
+	var zw *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		zw = gzip.NewWriter(tmp)
+		w = zw
+	}
+	resp, err := kvf.RunExport(httpClient, req, w)
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return err
+	}
+	log.Println("exported", resp.Count, "recs to", path, "in", time.Since(start))
+	return nil
+}
+
+// Func importFile sends the file to the server, a .gz file is sent compressed as is.
+func importFile(req kvf.ImportRequest, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoding := ""
+	if strings.HasSuffix(path, ".gz") {
+		encoding = kvf.EncodingGzip
+	}
+	start := time.Now()
+	resp, err := kvf.RunImport(&http.Client{}, req, f, encoding)
+	if resp != nil {
+		log.Println(resp.Status, resp.Msg)
+	}
+	if err != nil {
+		return err
+	}
+	log.Println("imported", resp.PutCnt, "recs in", time.Since(start))
+	return nil
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -3,6 +3,7 @@ module kvfun
 go 1.21.1
 
 require (
+	github.com/klauspost/compress v1.17.11
 	github.com/valyala/fastjson v1.6.4
 	go.etcd.io/bbolt v1.3.7
 )
@@ -0,0 +1,98 @@
+package kvf
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const benchRecCnt = 20000
+
+// Func openBenchDB returns a db with benchRecCnt recs in bkt "location", shaped like core.Location
+// (same data as bench/bench.go, which compares recEval with the old reparse approach).
+func openBenchDB(b *testing.B) *bolt.DB {
+	b.Helper()
+	db, err := bolt.Open(filepath.Join(b.TempDir(), "bench.db"), 0600, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	states := []string{"PA", "TX", "NY", "OH", "CA", "FL", "NJ", "GA"}
+	cities := []string{"Lakewood", "Westfield", "Springfield", "Lake City", "Franklin", "Bristol", "Clinton", "Madison"}
+	streets := []string{"West", "North", "Main", "Oak"}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket([]byte("location"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < benchRecCnt; i++ {
+			id := fmt.Sprintf("5b%022x", i*7919)
+			rec := fmt.Sprintf(`{"id":%q,"address":"%d %s Ave","city":%q,"st":%q,"zip":"%05d","locationType":%d,"lastActionDt":"202%d-%02d-%02d","notes":["Note #1","Note #2"]}`,
+				id, 100+i%900, streets[i%4], cities[(i/3)%len(cities)], states[i%len(states)], 10000+i%89999, 1+i%3, 1+i%3, 1+i%12, 1+i%28)
+			if err := bkt.Put([]byte(id), []byte(rec)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return db
+}
+
+// Func benchQry runs Qry with req b.N times in 1 read tx.
+func benchQry(b *testing.B, req QryRequest) {
+	db := openBenchDB(b)
+	req.BktName = "location"
+	tx, err := db.Begin(false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if resp := Qry(context.Background(), tx, &req); resp.Status != Ok {
+			b.Fatal(resp.Msg)
+		}
+	}
+}
+
+func BenchmarkQryFind(b *testing.B) {
+	benchQry(b, QryRequest{FindConditions: []FindCondition{{Fld: "st", Op: Matches, ValStr: "PA"}}})
+}
+
+func BenchmarkQryFind3Conditions(b *testing.B) {
+	benchQry(b, QryRequest{FindConditions: []FindCondition{
+		{Fld: "st", Op: Matches, ValStr: "PA"},
+		{Fld: "locationType", Op: GreaterThan, ValInt: 1},
+		{Fld: "address", Op: Contains, ValStr: "west"},
+	}})
+}
+
+func BenchmarkQryFindSort(b *testing.B) {
+	benchQry(b, QryRequest{
+		FindConditions: []FindCondition{{Fld: "locationType", Op: GreaterThan, ValInt: 1}},
+		SortFlds:       []SortKey{{Fld: "locationType", Dir: DescInt}, {Fld: "city", Dir: AscStr}},
+	})
+}
+
+func BenchmarkQrySortAll(b *testing.B) {
+	benchQry(b, QryRequest{SortFlds: []SortKey{{Fld: "city", Dir: AscStr}, {Fld: "address", Dir: AscStr}}})
+}
+
+func BenchmarkQryTopN(b *testing.B) {
+	benchQry(b, QryRequest{SortFlds: []SortKey{{Fld: "lastActionDt", Dir: DescStr}}, Limit: 20})
+}
+
+func BenchmarkQryParallelFind(b *testing.B) {
+	benchQry(b, QryRequest{FindConditions: []FindCondition{{Fld: "st", Op: Matches, ValStr: "PA"}}, Parallel: 4})
+}
+
+func BenchmarkQryParallelTopN(b *testing.B) {
+	benchQry(b, QryRequest{SortFlds: []SortKey{{Fld: "lastActionDt", Dir: DescStr}}, Limit: 20, Parallel: 4})
+}
@@ -0,0 +1,56 @@
+// File cancel.go contains request cancellation support for the handlers.
+// The server passes each handler a context.Context that is done when the client goes away
+// or the op time limit is reached (see server/config.go timeouts). Read handlers check it
+// every ctxCheckInterval recs in cursor loops and during sorts, then return a Fail Response
+// with CodeTimeout or CodeCanceled. Write handlers only check it before the first write,
+// so a write tx is never committed with part of a request applied.
+
+package kvf
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+const ctxCheckInterval = 256 // ctx.Err() takes a lock, so it is not checked for every rec
+
+// Func canceled returns true if n is a multiple of ctxCheckInterval and ctx is done.
+// Cursor loops pass their scanned count.
+func canceled(ctx context.Context, n int) bool {
+	return n%ctxCheckInterval == 0 && ctx.Err() != nil
+}
+
+// Func ctxFail sets resp to Fail with CodeTimeout or CodeCanceled, based on ctx.Err().
+func ctxFail(ctx context.Context, resp *Response) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		resp.fail(CodeTimeout, "Request Time Limit Exceeded")
+		return
+	}
+	resp.fail(CodeCanceled, "Request Canceled")
+}
+
+// sortCanceled is the panic value used to end a sort early, see sortItems.
+type sortCanceled struct{}
+
+// Func sortItems sorts items by sortFlds then key, returning ctx.Err() if ctx is done before the sort completes.
+// slices.SortFunc cannot be stopped, so the compare func panics and the panic is recovered here.
+func sortItems(ctx context.Context, items []qryItem, sortFlds []SortKey) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(sortCanceled); !ok {
+				panic(r)
+			}
+			err = ctx.Err()
+		}
+	}()
+	var n int
+	slices.SortFunc(items, func(a, b qryItem) int { // slices pkg added in Go 1.21
+		n++
+		if canceled(ctx, n) {
+			panic(sortCanceled{})
+		}
+		return compareItems(a, b, sortFlds) // key tiebreak, same order as the top N heap
+	})
+	return ctx.Err()
+}
@@ -0,0 +1,70 @@
+package kvf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// Func sortTestItems returns n items with descending int sort values.
+func sortTestItems(n int) []qryItem {
+	items := make([]qryItem, n)
+	for i := range items {
+		items[i] = qryItem{key: fmt.Sprintf("k%04d", i), sortVals: []sortVal{{n: n - i}}}
+	}
+	return items
+}
+
+func TestSortItemsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := sortItems(ctx, sortTestItems(1000), []SortKey{{Fld: "n", Dir: AscInt}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+
+	items := sortTestItems(1000)
+	if err := sortItems(context.Background(), items, []SortKey{{Fld: "n", Dir: AscInt}}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.IsSortedFunc(items, func(a, b qryItem) int { return compareItems(a, b, []SortKey{{Fld: "n", Dir: AscInt}}) }) {
+		t.Error("items not sorted")
+	}
+}
+
+// Panics other than the cancel panic are not recovered by sortItems.
+func TestSortItemsRepanics(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("sortItems did not panic")
+		}
+		if _, ok := r.(sortCanceled); ok {
+			t.Fatal("sortItems panicked with sortCanceled")
+		}
+	}()
+	items := sortTestItems(10)
+	for i := range items {
+		items[i].sortVals = nil // fewer sortVals than sortFlds, index out of range in compareSortVals
+	}
+	sortItems(context.Background(), items, []SortKey{{Fld: "n", Dir: AscInt}})
+}
+
+func TestQryCanceled(t *testing.T) {
+	db := openTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	for _, parallel := range []int{0, 4} {
+		resp := Qry(ctx, tx, &QryRequest{BktName: "many", SortFlds: []SortKey{{Fld: "n", Dir: AscInt}}, Parallel: parallel})
+		if resp.Status != Fail || resp.Code != CodeCanceled || resp.Recs != nil {
+			t.Errorf("parallel %d: status %d code %s, %d recs, want Fail %s", parallel, resp.Status, resp.Code, len(resp.Recs), CodeCanceled)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// File changelog.go contains the change data capture (CDC) log. When ChangeLog is true, every Put, PutOne,
+// Delete and Bkt mutation appends a ChangeEntry to the ChangeLogBkt bkt in the same write tx, so an entry
+// exists if and only if its change was committed. Entries are keyed by a sequence number from bolt
+// NextSequence, zero padded so key order is sequence order. Consumers read entries from a sequence
+// number onward with a ChangesRequest (see Changes and RunChanges) and keep the last Seq they processed.
+//   {"seq":42,"time":"2024-01-01T12:00:00.123Z","op":"put","bkt":"location","key":"k0001","after":{...}}
+
+package kvf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const ChangeLogBkt = "_changes" // reserved bkt, cannot be written by requests
+
+// ChangeEntry.Op values
+const (
+	ChangePut       = "put" // add or replace, from Put, PutOne and /import
+	ChangeDelete    = "delete"
+	ChangeBktCreate = "bkt-create"
+	ChangeBktDelete = "bkt-delete" // recs deleted with the bkt do not get their own entries
+)
+
+// ChangeLogValues values, which rec values are stored in each entry
+const (
+	ChangeValuesNone  = "none"  // op, bkt and key only
+	ChangeValuesAfter = "after" // new value of puts
+	ChangeValuesBoth  = "both"  // value before the put or delete, and new value of puts
+)
+
+// Change log settings, the server pgm sets them from its config (changeLog).
+var (
+	ChangeLog       bool // if true, write handlers append ChangeEntries
+	ChangeLogValues = ChangeValuesNone
+	ChangeLogMax    uint64 // if > 0, oldest entries are deleted to keep at most this many
+)
+
+const DefaultChangesLimit = 1000 // ChangesRequest.Limit if 0
+
+// ChangeEntry is 1 committed mutation. Values that are not valid json are stored in BeforeRaw/AfterRaw.
+type ChangeEntry struct {
+	Seq       uint64          `json:"seq"`
+	Time      string          `json:"time"` // time of the write, RFC 3339 UTC with milliseconds
+	Op        string          `json:"op"`   // see ChangePut etc.
+	BktName   string          `json:"bkt"`
+	Key       string          `json:"key,omitempty"` // "" for bkt ops
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	BeforeRaw []byte          `json:"beforeRaw,omitempty"`
+	AfterRaw  []byte          `json:"afterRaw,omitempty"`
+}
+
+// ChangesRequest reads change log entries with Seq >= FromSeq, in Seq order.
+type ChangesRequest struct {
+	FromSeq uint64 `json:"fromSeq"` // 1st seq is 1, use the last Seq processed + 1 to continue
+	BktName string `json:"bktName"` // optional, only entries for this bkt
+	Limit   int    `json:"limit"`   // max entries returned, default DefaultChangesLimit
+}
+
+// Func LogChange appends a ChangeEntry to the change log in tx, if ChangeLog is set.
+// Before and after are the rec values, nil if none. They are only stored as ChangeLogValues allows.
+// Handlers call it after each successful mutation, an error must fail the request so the tx is rolled back.
+// After the tx commits, the caller calls NotifyChanges once to wake watchers (see watch.go).
+func LogChange(tx *bolt.Tx, op, bktName string, key, before, after []byte) error {
+	if !ChangeLog {
+		return nil
+	}
+	changes, err := tx.CreateBucketIfNotExists([]byte(ChangeLogBkt))
+	if err != nil {
+		return err
+	}
+	seq, err := changes.NextSequence()
+	if err != nil {
+		return err
+	}
+	entry := ChangeEntry{
+		Seq:     seq,
+		Time:    time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		Op:      op,
+		BktName: bktName,
+		Key:     string(key),
+	}
+	if ChangeLogValues == ChangeValuesBoth {
+		entry.Before, entry.BeforeRaw = changeVal(before)
+	}
+	if ChangeLogValues == ChangeValuesBoth || ChangeLogValues == ChangeValuesAfter {
+		entry.After, entry.AfterRaw = changeVal(after)
+	}
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	if err := changes.Put(changeKey(seq), data); err != nil {
+		return err
+	}
+	if ChangeLogMax > 0 && seq > ChangeLogMax {
+		return trimChanges(changes, changeKey(seq-ChangeLogMax))
+	}
+	return nil
+}
+
+// Func trimChanges deletes the entries up to and including last. Usually that is 1 entry, but more after
+// ChangeLogMax was lowered or the log was written while trimming was off.
+func trimChanges(changes *bolt.Bucket, last []byte) error {
+	csr := changes.Cursor()
+	// First again after each Delete, Next after a cursor Delete can skip a key
+	for k, _ := csr.First(); k != nil && bytes.Compare(k, last) <= 0; k, _ = csr.First() {
+		if err := csr.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Func logChangeFail calls LogChange and sets resp to Fail if it returns an error.
+func logChangeFail(tx *bolt.Tx, resp *Response, op, bktName string, key, before, after []byte) bool {
+	if err := LogChange(tx, op, bktName, key, before, after); err != nil {
+		slog.Error("change log write failed", "bkt", bktName, "key", string(key), "err", err)
+		resp.fail(boltErrCode(err), "Change Log Write Failed - "+err.Error())
+		return false
+	}
+	return true
+}
+
+// ChangeBefore returns the current value of key, if the change log stores before values, otherwise nil.
+// Call before changing key, pass the result to LogChange.
+func ChangeBefore(bkt *bolt.Bucket, key []byte) []byte {
+	if ChangeLog && ChangeLogValues == ChangeValuesBoth {
+		return bkt.Get(key)
+	}
+	return nil
+}
+
+// Func changeVal returns v as json, or as raw bytes if it is not valid json.
+func changeVal(v []byte) (json.RawMessage, []byte) {
+	if v == nil {
+		return nil, nil
+	}
+	if json.Valid(v) {
+		return v, nil
+	}
+	return nil, v
+}
+
+// Func changeKey returns the change log key of seq, 20 digits so keys sort in seq order.
+func changeKey(seq uint64) []byte {
+	return []byte(fmt.Sprintf("%020d", seq))
+}
+
+// Changes returns change log entries from req.FromSeq onward in Response.Recs, 1 ChangeEntry json per rec.
+// If entries before FromSeq were deleted (ChangeLogMax), the 1st entry returned has a higher Seq than requested,
+// consumers can compare them to detect the gap. An empty or missing log returns Ok with no recs.
+func Changes(ctx context.Context, tx *bolt.Tx, req *ChangesRequest) *Response {
+
+	resp := new(Response)
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultChangesLimit
+	}
+	resp.Status = Ok
+	changes := tx.Bucket([]byte(ChangeLogBkt))
+	if changes == nil {
+		return resp
+	}
+	csr := changes.Cursor()
+	for k, v := csr.Seek(changeKey(req.FromSeq)); k != nil; k, v = csr.Next() {
+		if canceled(ctx, resp.scanned) {
+			ctxFail(ctx, resp)
+			return resp
+		}
+		resp.scanned++
+		if req.BktName != "" && recGetStr(v, "bkt") != req.BktName {
+			continue
+		}
+		vcopy := make([]byte, len(v))
+		copy(vcopy, v) // ref to v are invalid outside tx
+		resp.Recs = append(resp.Recs, vcopy)
+		if len(resp.Recs) == limit {
+			break
+		}
+	}
+	resp.Count = len(resp.Recs)
+	resp.Exists = resp.Count > 0
+	return resp
+}
+
+// RunChanges reads change log entries from fromSeq onward, bktName "" for all bkts.
+// Limit 0 uses the server default. To follow the log, call again with the last entry Seq + 1.
+func RunChanges(httpClient *http.Client, fromSeq uint64, bktName string, limit int) ([]ChangeEntry, error) {
+	resp, err := Run(httpClient, "changes", ChangesRequest{FromSeq: fromSeq, BktName: bktName, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ChangeEntry, len(resp.Recs))
+	for i, rec := range resp.Recs {
+		if err := json.Unmarshal(rec, &entries[i]); err != nil {
+			return nil, fmt.Errorf("change entry %d: %w", i, err)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,68 @@
+package kvf
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Func logChanges appends n put entries to the change log of db.
+func logChanges(t *testing.T, db *bolt.DB, n int) {
+	t.Helper()
+	err := db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < n; i++ {
+			if err := LogChange(tx, ChangePut, "bkt", []byte("k"), nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Func changeSeqs returns the seqs in the change log of db.
+func changeSeqs(t *testing.T, db *bolt.DB) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(ChangeLogBkt)).ForEach(func(k, _ []byte) error {
+			seq, err := strconv.ParseUint(string(k), 10, 64)
+			seqs = append(seqs, seq)
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seqs
+}
+
+func TestChangeLogTrim(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer func(log bool, max uint64) { ChangeLog, ChangeLogMax = log, max }(ChangeLog, ChangeLogMax)
+	ChangeLog = true
+
+	ChangeLogMax = 0
+	logChanges(t, db, 10)
+	if seqs := changeSeqs(t, db); len(seqs) != 10 {
+		t.Fatalf("no max: %d entries, want 10", len(seqs))
+	}
+
+	ChangeLogMax = 3 // lowered, the next append trims all older entries
+	logChanges(t, db, 1)
+	if seqs := changeSeqs(t, db); len(seqs) != 3 || seqs[0] != 9 || seqs[2] != 11 {
+		t.Fatalf("max 3: seqs %v, want [9 10 11]", seqs)
+	}
+	logChanges(t, db, 5)
+	if seqs := changeSeqs(t, db); len(seqs) != 3 || seqs[0] != 14 || seqs[2] != 16 {
+		t.Fatalf("max 3: seqs %v, want [14 15 16]", seqs)
+	}
+}
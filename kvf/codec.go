@@ -0,0 +1,90 @@
+// File codec.go contains the wire codecs used to encode requests and responses.
+// Client selects a codec by setting WireCodec (see run.go), which sets the Content-Type and Accept headers.
+// Server decodes the request using the Content-Type codec and encodes the response using the Accept codec.
+// Note - recs inside requests/responses are always json, codecs only change how the Request/Response is encoded.
+
+package kvf
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"mime"
+	"strings"
+)
+
+// Codec encodes and decodes Request and Response types.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	GobCodec     Codec = gobCodec{}
+	MsgpackCodec Codec = msgpackCodec{} // see msgpack.go
+)
+
+// codecs by content type, "application/x-msgpack" is also accepted for msgpack
+var codecs = map[string]Codec{
+	"application/json":      JSONCodec,
+	"application/x-gob":     GobCodec,
+	"application/msgpack":   MsgpackCodec,
+	"application/x-msgpack": MsgpackCodec,
+}
+
+// CodecFor returns the codec for a Content-Type header value.
+// Empty contentType returns JSONCodec. False is returned if content type is not supported.
+func CodecFor(contentType string) (Codec, bool) {
+	if contentType == "" {
+		return JSONCodec, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	codec, found := codecs[mediaType]
+	return codec, found
+}
+
+// AcceptCodec returns the 1st supported codec listed in an Accept header value.
+// If accept is empty, "*/*", or lists no supported codec, fallback is returned.
+func AcceptCodec(accept string, fallback Codec) Codec {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if codec, found := codecs[mediaType]; found {
+			return codec
+		}
+	}
+	return fallback
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                { return "application/json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string                { return "application/msgpack" }
+func (msgpackCodec) Marshal(v any) ([]byte, error)      { return msgpackMarshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return msgpackUnmarshal(data, v) }
@@ -0,0 +1,138 @@
+// File compress.go contains the body compression used by kvf.Run and the server program.
+// Client sends Accept-Encoding with the encodings below, server compresses responses at or above
+// its size threshold and sets Content-Encoding. Client decompresses responses transparently.
+// Request bodies are only compressed if the client pgm sets RequestEncoding (server must support it).
+
+package kvf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported Content-Encoding values
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// AcceptEncodings is sent by kvf.Run in the Accept-Encoding header, in order of preference.
+var AcceptEncodings = EncodingZstd + ", " + EncodingGzip
+
+var RequestEncoding string    // client pgm can set to EncodingGzip or EncodingZstd to compress request bodies
+var RequestCompressMin = 1024 // request bodies smaller than this are not compressed
+
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// zstd encoder is safe for concurrent EncodeAll calls, so 1 is shared
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+)
+
+func initZstd() {
+	zstdEncoder, _ = zstd.NewWriter(nil)
+}
+
+// SupportedEncoding returns true if encoding can be used for Content-Encoding.
+func SupportedEncoding(encoding string) bool {
+	return encoding == EncodingGzip || encoding == EncodingZstd
+}
+
+// AcceptEncoding returns the preferred supported encoding listed in an Accept-Encoding header value.
+// Zstd is preferred over gzip. Encodings with q=0 are skipped. Returns "" if none are supported.
+func AcceptEncoding(accept string) string {
+	var gzipOk, zstdOk bool
+	for _, part := range strings.Split(accept, ",") {
+		encoding, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if q, found := params["q"]; found {
+			if qval, err := strconv.ParseFloat(q, 64); err == nil && qval == 0 {
+				continue
+			}
+		}
+		switch encoding {
+		case EncodingGzip:
+			gzipOk = true
+		case EncodingZstd:
+			zstdOk = true
+		}
+	}
+	switch {
+	case zstdOk:
+		return EncodingZstd
+	case gzipOk:
+		return EncodingGzip
+	}
+	return ""
+}
+
+// Compress returns data compressed using encoding.
+func Compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case EncodingZstd:
+		zstdOnce.Do(initZstd)
+		return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/4)), nil
+	}
+	return nil, errUnsupportedEncoding
+}
+
+// NewCompressWriter returns a writer compressing to w, used for streamed responses.
+// Close must be called to flush remaining data, it does not close w.
+func NewCompressWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	case EncodingZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, errUnsupportedEncoding
+}
+
+// NewDecompressReader returns a reader decompressing r. If encoding is "" or "identity", r is returned as is.
+func NewDecompressReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "", "identity":
+		return io.NopCloser(r), nil
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	case EncodingZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, errUnsupportedEncoding
+}
+
+// decompressBody wraps body so reads are decompressed, closing the result closes body.
+type decompressBody struct {
+	io.ReadCloser           // decompressor
+	body          io.Closer // original body
+}
+
+func (d *decompressBody) Close() error {
+	d.ReadCloser.Close()
+	return d.body.Close()
+}
@@ -0,0 +1,124 @@
+// File errors.go contains the error codes loaded into Response.Code and the Error type returned by kvf.Run.
+// Handlers set Response.Code along with Status Fail (and for some Warnings), server sends the matching http status.
+// Client code can check errors returned by Run with errors.Is or errors.As, ex.
+//   resp, err := kvf.Run(httpClient, "getone", req)
+//   if errors.Is(err, kvf.ErrBktNotFound) { ... }
+//   var kvfErr *kvf.Error
+//   if errors.As(err, &kvfErr) { log.Println(kvfErr.Code, kvfErr.HTTPStatus) }
+
+package kvf
+
+import "net/http"
+
+// Response Code values
+const (
+	CodeBktNotFound      = "bkt-not-found"      // requested bucket does not exist
+	CodeKeyMissing       = "key-missing"        // requested key(s) not found, Status is Warning
+	CodeValidation       = "validation"         // request values are invalid, ex. KeyField not in rec, invalid ResultMode
+	CodeConflict         = "conflict"           // request conflicts with current db state, ex. create of existing bucket
+	CodeBadRequest       = "bad-request"        // request body could not be read or decoded
+	CodeMethodNotAllowed = "method-not-allowed" // http method not allowed for the endpoint, Allow header lists the allowed methods
+	CodeTooLarge         = "too-large"          // request body exceeds server limit
+	CodeUnsupported      = "unsupported"        // request Content-Type or Content-Encoding not supported
+	CodeUnauthorized     = "unauthorized"       // missing or invalid credentials
+	CodeForbidden        = "forbidden"          // credentials valid, but operation not allowed
+	CodeTimeout          = "timeout"            // server time limit for the op was reached, see cancel.go
+	CodeCanceled         = "canceled"           // client went away before the request completed
+	CodeInternal         = "internal"           // db or server failure
+)
+
+// StatusClientClosedRequest is sent with CodeCanceled (nginx convention), the client is usually gone by then.
+const StatusClientClosedRequest = 499
+
+var codeHTTPStatus = map[string]int{
+	CodeBktNotFound:      http.StatusNotFound,
+	CodeKeyMissing:       http.StatusNotFound,
+	CodeValidation:       http.StatusUnprocessableEntity,
+	CodeConflict:         http.StatusConflict,
+	CodeBadRequest:       http.StatusBadRequest,
+	CodeMethodNotAllowed: http.StatusMethodNotAllowed,
+	CodeTooLarge:         http.StatusRequestEntityTooLarge,
+	CodeUnsupported:      http.StatusUnsupportedMediaType,
+	CodeUnauthorized:     http.StatusUnauthorized,
+	CodeForbidden:        http.StatusForbidden,
+	CodeTimeout:          http.StatusGatewayTimeout,
+	CodeCanceled:         StatusClientClosedRequest,
+	CodeInternal:         http.StatusInternalServerError,
+}
+
+// HTTPStatus returns the http status the server sends for resp.
+// Ok and Warning responses are sent as 200, Warning recs (if any) are still valid.
+func HTTPStatus(resp *Response) int {
+	if resp.Status != Fail {
+		return http.StatusOK
+	}
+	if status, found := codeHTTPStatus[resp.Code]; found {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// codeFor returns the Code matching an http status, used when the server did not send a Response.
+// 404 is not mapped, without a Response it means the route does not exist (ex. older server).
+func codeFor(httpStatus int) string {
+	for code, status := range codeHTTPStatus {
+		if status == httpStatus && status != http.StatusNotFound {
+			return code
+		}
+	}
+	return CodeInternal
+}
+
+// Error is returned by Run when Response.Status is Fail, and by Response.Err.
+type Error struct {
+	Code       string // see Code constants above, "" if server did not send a code (older servers)
+	Msg        string // Response.Msg
+	HTTPStatus int    // 0 if not known, ex. Error created by Response.Err
+	RequestID  string // Response.RequestID, identifies the request in server logs
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return e.Msg
+	}
+	return e.Code + " - " + e.Msg
+}
+
+// Is matches errors with the same Code, so errors.Is(err, kvf.ErrBktNotFound) works for any bkt-not-found Error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Errors for use with errors.Is, only Code is compared
+var (
+	ErrBktNotFound      = &Error{Code: CodeBktNotFound}
+	ErrKeyMissing       = &Error{Code: CodeKeyMissing}
+	ErrValidation       = &Error{Code: CodeValidation}
+	ErrConflict         = &Error{Code: CodeConflict}
+	ErrBadRequest       = &Error{Code: CodeBadRequest}
+	ErrMethodNotAllowed = &Error{Code: CodeMethodNotAllowed}
+	ErrTooLarge         = &Error{Code: CodeTooLarge}
+	ErrUnsupported      = &Error{Code: CodeUnsupported}
+	ErrUnauthorized     = &Error{Code: CodeUnauthorized}
+	ErrForbidden        = &Error{Code: CodeForbidden}
+	ErrTimeout          = &Error{Code: CodeTimeout}
+	ErrCanceled         = &Error{Code: CodeCanceled}
+	ErrInternal         = &Error{Code: CodeInternal}
+)
+
+// Err returns resp as an *Error, or nil if Status is Ok.
+// Run only returns an error for Fail, use Err to also check Warnings, ex. errors.Is(resp.Err(), kvf.ErrKeyMissing).
+func (resp *Response) Err() error {
+	if resp.Status == Ok {
+		return nil
+	}
+	return &Error{Code: resp.Code, Msg: resp.Msg, RequestID: resp.RequestID}
+}
+
+// Func fail sets resp to Status Fail with code and msg.
+func (resp *Response) fail(code, msg string) {
+	resp.Status = Fail
+	resp.Code = code
+	resp.Msg = msg
+}
@@ -0,0 +1,203 @@
+// File export.go contains the logical export/import format, the Export handler and the client funcs
+// for the server /export and /import endpoints. Unlike /admin/backup (a copy of the bolt file),
+// an export is portable NDJSON that other tools can load, ex. Snowflake.
+// Each bkt starts with a header line, followed by 1 line per rec in key order:
+//   {"header":{"format":"kvf-export-1","bkt":"location","count":2,"exported":"2024-01-01T12:00:00Z"}}
+//   {"key":"k0001","rec":{"id":"k0001","city":"..."}}
+//   {"key":"k0002","rec":{"id":"k0002","city":"..."}}
+// Recs that are not valid json are written base64 encoded in "raw" instead of "rec".
+// Rec lines can be loaded directly by tools that skip the header lines, ex. where $1:key is not null.
+
+package kvf
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const ExportFormat = "kvf-export-1" // ExportHeader.Format, changes if the line format changes
+
+// ExportHeader describes the recs of 1 bkt that follow it.
+type ExportHeader struct {
+	Format   string `json:"format"`
+	BktName  string `json:"bkt"`
+	Count    int    `json:"count"`              // rec lines following this header
+	Exported string `json:"exported"`           // time of export, RFC 3339, all bkts are from the same read tx
+	StartKey string `json:"startKey,omitempty"` // key range of the export, if limited
+	EndKey   string `json:"endKey,omitempty"`
+}
+
+// ExportLine is 1 line of an export, either a Header or a rec.
+type ExportLine struct {
+	Header *ExportHeader   `json:"header,omitempty"`
+	Key    string          `json:"key,omitempty"`
+	Rec    json.RawMessage `json:"rec,omitempty"`
+	Raw    []byte          `json:"raw,omitempty"` // rec that is not valid json
+}
+
+// Import modes used in ImportRequest.Mode
+const (
+	ImportMerge   = "merge"   // default, recs are added or replaced, other recs in the bkt are kept
+	ImportReplace = "replace" // bkt is deleted and recreated before its recs are imported
+)
+
+// ExportRequest is used to export bkts as NDJSON, see RunExport.
+type ExportRequest struct {
+	BktNames []string `json:"bktNames"` // bkts to export, nil or empty exports all bkts except ChangeLogBkt
+	StartKey string   `json:"startKey"` // optional key range, applied to every bkt
+	EndKey   string   `json:"endKey"`
+}
+
+// ImportRequest holds the /import options, sent as url query params since the body is the NDJSON export.
+type ImportRequest struct {
+	BktNames []string // only import these bkts, nil imports all bkts in the export
+	Mode     string   // ImportMerge (default) or ImportReplace
+	StartKey string   // optional key range, recs outside it are skipped
+	EndKey   string
+}
+
+// Export writes the requested bkts to w in the export format, within the read tx so all bkts are consistent.
+// All bkts are checked before anything is written, so a missing bkt returns a Fail Response with nothing streamed.
+// Response.Count is the number of recs written.
+func Export(ctx context.Context, tx *bolt.Tx, req *ExportRequest, w io.Writer) *Response {
+
+	resp := new(Response)
+	names := req.BktNames
+	if len(names) == 0 {
+		tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if string(name) != ChangeLogBkt { // only exported if requested by name
+				names = append(names, string(name))
+			}
+			return nil
+		})
+	}
+	for _, name := range names {
+		if openBkt(tx, resp, name) == nil {
+			return resp
+		}
+	}
+
+	exported := time.Now().UTC().Format(time.RFC3339)
+	for _, name := range names {
+		csr := tx.Bucket([]byte(name)).Cursor()
+		header := &ExportHeader{Format: ExportFormat, BktName: name, Exported: exported, StartKey: req.StartKey, EndKey: req.EndKey}
+		for k, v := exportFirst(csr, req); k != nil && inRange(k, req.EndKey); k, v = csr.Next() {
+			if v != nil { // nil is a nested bkt, not used by kvf
+				header.Count++
+			}
+		}
+		if !writeExportLine(w, resp, &ExportLine{Header: header}) {
+			return resp
+		}
+		slog.Debug("export bkt", "bkt", name, "count", header.Count)
+		for k, v := exportFirst(csr, req); k != nil && inRange(k, req.EndKey); k, v = csr.Next() {
+			if v == nil {
+				continue
+			}
+			if canceled(ctx, resp.scanned) {
+				ctxFail(ctx, resp)
+				return resp
+			}
+			resp.scanned++
+			line := &ExportLine{Key: string(k)}
+			if json.Valid(v) {
+				line.Rec = v
+			} else {
+				line.Raw = v
+			}
+			if !writeExportLine(w, resp, line) {
+				return resp
+			}
+			resp.Count++
+		}
+	}
+	resp.Exists = resp.Count > 0
+	resp.Status = Ok
+	return resp
+}
+
+func exportFirst(csr *bolt.Cursor, req *ExportRequest) ([]byte, []byte) {
+	if req.StartKey == "" {
+		return csr.First()
+	}
+	return csr.Seek([]byte(req.StartKey))
+}
+
+// Func inRange returns true if k <= endKey, or endKey is "".
+func inRange(k []byte, endKey string) bool {
+	return endKey == "" || string(k) <= endKey
+}
+
+// Func writeExportLine writes line as json followed by a newline.
+// If the write fails, resp is set to Fail and false is returned.
+func writeExportLine(w io.Writer, resp *Response, line *ExportLine) bool {
+	data, err := json.Marshal(line) // compacts Rec, so each line is a single line
+	if err == nil {
+		data = append(data, '\n')
+		_, err = w.Write(data)
+	}
+	if err != nil {
+		slog.Warn("export write failed", "err", err)
+		resp.fail(CodeInternal, "Export Write Failed - "+err.Error())
+		return false
+	}
+	return true
+}
+
+// RunExport streams the requested bkts from the server and writes the export to w.
+// The returned Response Count is the number of recs exported. If the server fails part way,
+// the Fail Response and an *Error are returned, w then holds an incomplete export.
+func RunExport(httpClient *http.Client, req ExportRequest, w io.Writer) (*Response, error) {
+	return RunStream(httpClient, "export", &req, func(line []byte) error {
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte{'\n'})
+		return err
+	})
+}
+
+// RunImport sends an export (ex. a file written by RunExport) to the server /import endpoint.
+// If the export is compressed, set encoding to EncodingGzip or EncodingZstd, it is sent as is.
+// Response.PutCnt is the number of recs imported, Response.Msg has counts by bkt.
+func RunImport(httpClient *http.Client, req ImportRequest, r io.Reader, encoding string) (*Response, error) {
+	query := url.Values{}
+	for _, name := range req.BktNames {
+		query.Add("bkt", name)
+	}
+	if req.Mode != "" {
+		query.Set("mode", req.Mode)
+	}
+	if req.StartKey != "" {
+		query.Set("startKey", req.StartKey)
+	}
+	if req.EndKey != "" {
+		query.Set("endKey", req.EndKey)
+	}
+	httpReq, err := http.NewRequest("POST", BaseURL+"import?"+query.Encode(), r)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Add("Content-Type", NDJSONContentType)
+	httpReq.Header.Add("Accept", JSONCodec.ContentType())
+	if encoding != "" {
+		httpReq.Header.Add("Content-Encoding", encoding)
+	}
+	resp, err := send(httpClient, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return checkResponse(resp, body)
+}
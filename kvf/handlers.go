@@ -4,13 +4,14 @@
 //   Once Transaction(tx) has ended, refs to db vals may become invalid.
 //   The json.Marshal of Response occurs outside the tx.
 //   Not sure if this step is necessary, but better safe than sorry.
+// Each func takes the request ctx, when it is done read funcs stop scanning and return a Fail Response (see cancel.go).
 
 package kvf
 
 import (
-	"cmp"
-	"log"
-	"slices"
+	"context"
+	"errors"
+	"log/slog"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -18,7 +19,7 @@ import (
 var DefaultQryRespSize = 300 // response slice initial allocation for this size
 
 // Get returns recs with keys matching requested keys.
-func Get(tx *bolt.Tx, req *GetRequest) *Response {
+func Get(ctx context.Context, tx *bolt.Tx, req *GetRequest) *Response {
 
 	resp := new(Response)
 	resp.Status = Ok // may be changed to Warning below if key not found
@@ -30,10 +31,16 @@ func Get(tx *bolt.Tx, req *GetRequest) *Response {
 	resp.Recs = make([][]byte, 0, 20)
 
 	for _, key := range req.Keys {
+		if canceled(ctx, resp.scanned) {
+			ctxFail(ctx, resp)
+			return resp
+		}
 		v := bkt.Get([]byte(key))
+		resp.scanned++
 		if v == nil {
-			log.Println("key not found", key)
+			slog.Debug("key not found", "bkt", req.BktName, "key", key)
 			resp.Status = Warning
+			resp.Code = CodeKeyMissing
 			resp.Msg = "Requested Record(s) Not Found"
 			continue // NOTE - THIS BEHAVIOUR MAY NOT BE APPROPRIATE FOR ALL SITUATIONS
 		}
@@ -45,17 +52,23 @@ func Get(tx *bolt.Tx, req *GetRequest) *Response {
 }
 
 // GetOne returns a rec where key matches requested key.
-func GetOne(tx *bolt.Tx, req *GetOneRequest) *Response {
+func GetOne(ctx context.Context, tx *bolt.Tx, req *GetOneRequest) *Response {
 
 	resp := new(Response)
+	if ctx.Err() != nil {
+		ctxFail(ctx, resp)
+		return resp
+	}
 	bkt := openBkt(tx, resp, req.BktName)
 	if bkt == nil {
 		return resp
 	}
 	v := bkt.Get([]byte(req.Key))
+	resp.scanned = 1
 	if v == nil {
-		log.Println("key not found", req.Key)
+		slog.Debug("key not found", "bkt", req.BktName, "key", req.Key)
 		resp.Status = Warning
+		resp.Code = CodeKeyMissing
 		resp.Msg = "Requested Record Not Found - " + req.Key
 		return resp
 	}
@@ -70,15 +83,24 @@ func GetOne(tx *bolt.Tx, req *GetOneRequest) *Response {
 // Optionally, Start and End keys can be included in the request.
 // If StartKey != "", then result begins at 1st key >= Start key.
 // If EndKey != "", then result ends at last key <= End key.
-func GetAll(tx *bolt.Tx, req *GetAllRequest) *Response {
+// If ResultMode is "count" or "exists", Response.Recs is not loaded (see countOrExists).
+func GetAll(ctx context.Context, tx *bolt.Tx, req *GetAllRequest) *Response {
 
 	resp := new(Response)
+	if !validResultMode(resp, req.ResultMode) {
+		return resp
+	}
 	bkt := openBkt(tx, resp, req.BktName)
 	if bkt == nil {
 		return resp
 	}
 	csr := bkt.Cursor()
 
+	if req.ResultMode != ResultRecs {
+		countOrExists(ctx, csr, resp, req.StartKey, req.EndKey, nil, req.ResultMode)
+		return resp
+	}
+
 	result := make([][]byte, 0, DefaultQryRespSize)
 
 	var k, v []byte
@@ -92,9 +114,16 @@ func GetAll(tx *bolt.Tx, req *GetAllRequest) *Response {
 		if req.EndKey != "" && key > req.EndKey {
 			break
 		}
+		if canceled(ctx, len(result)) {
+			ctxFail(ctx, resp)
+			return resp
+		}
 		result = append(result, v)
 		k, v = csr.Next()
 	}
+	resp.Count = len(result)
+	resp.Exists = resp.Count > 0
+	resp.scanned = len(result)
 	resp.Recs = make([][]byte, 0, len(result))
 	for _, v := range result {
 		vcopy := make([]byte, len(v))
@@ -107,9 +136,13 @@ func GetAll(tx *bolt.Tx, req *GetAllRequest) *Response {
 
 // Put adds or replaces records, based on existence of key.
 // The KeyField specified in the request is used as the key and this field must exist in all request.Recs.
-func Put(tx *bolt.Tx, req *PutRequest) *Response {
+func Put(ctx context.Context, tx *bolt.Tx, req *PutRequest) *Response {
 
 	resp := new(Response)
+	if ctx.Err() != nil { // checked before the first write only, see cancel.go
+		ctxFail(ctx, resp)
+		return resp
+	}
 	bkt := openBkt(tx, resp, req.BktName)
 	if bkt == nil {
 		return resp
@@ -117,17 +150,18 @@ func Put(tx *bolt.Tx, req *PutRequest) *Response {
 	for _, rec := range req.Recs { // req.Recs is [][]byte
 		key := recGetStr(rec, req.KeyField)
 		if key == "" {
-			log.Println("key value not found in record for specified KeyField - ", req.KeyField)
-			log.Println(string(rec))
-			resp.Status = Fail
-			resp.Msg = "key value not found in record for specified KeyField - " + req.KeyField
+			slog.Debug("key value not found in record", "keyField", req.KeyField, "rec", string(rec))
+			resp.fail(CodeValidation, "key value not found in record for specified KeyField - "+req.KeyField)
 			return resp
 		}
+		before := ChangeBefore(bkt, []byte(key))
 		err := bkt.Put([]byte(key), rec)
 		if err != nil {
-			log.Println("put failed", err)
-			resp.Status = Fail
-			resp.Msg = "Put Request Failed - " + err.Error()
+			slog.Error("put failed", "bkt", req.BktName, "key", key, "err", err)
+			resp.fail(boltErrCode(err), "Put Request Failed - "+err.Error())
+			return resp
+		}
+		if !logChangeFail(tx, resp, ChangePut, req.BktName, []byte(key), before, rec) { // see changelog.go
 			return resp
 		}
 		resp.PutCnt++
@@ -137,25 +171,31 @@ func Put(tx *bolt.Tx, req *PutRequest) *Response {
 }
 
 // PutOne adds or replaces a single record. Works same as Put.
-func PutOne(tx *bolt.Tx, req *PutOneRequest) *Response {
+func PutOne(ctx context.Context, tx *bolt.Tx, req *PutOneRequest) *Response {
 
 	resp := new(Response)
+	if ctx.Err() != nil { // checked before the first write only, see cancel.go
+		ctxFail(ctx, resp)
+		return resp
+	}
 	bkt := openBkt(tx, resp, req.BktName)
 	if bkt == nil {
 		return resp
 	}
 	key := recGetStr(req.Rec, req.KeyField)
 	if key == "" {
-		log.Println("key value not found in record", req.KeyField)
-		resp.Status = Fail
-		resp.Msg = "key value not found in record - " + req.KeyField
+		slog.Debug("key value not found in record", "keyField", req.KeyField)
+		resp.fail(CodeValidation, "key value not found in record - "+req.KeyField)
 		return resp
 	}
+	before := ChangeBefore(bkt, []byte(key))
 	err := bkt.Put([]byte(key), req.Rec)
 	if err != nil {
-		log.Println("put failed", err)
-		resp.Status = Fail
-		resp.Msg = "Put Request Failed - " + err.Error()
+		slog.Error("put failed", "bkt", req.BktName, "key", key, "err", err)
+		resp.fail(boltErrCode(err), "Put Request Failed - "+err.Error())
+		return resp
+	}
+	if !logChangeFail(tx, resp, ChangePut, req.BktName, []byte(key), before, req.Rec) {
 		return resp
 	}
 	resp.PutCnt = 1
@@ -164,19 +204,31 @@ func PutOne(tx *bolt.Tx, req *PutOneRequest) *Response {
 }
 
 // Delete deletes recs with keys matching specified keys.
-func Delete(tx *bolt.Tx, req *DeleteRequest) *Response {
+func Delete(ctx context.Context, tx *bolt.Tx, req *DeleteRequest) *Response {
 
 	resp := new(Response)
+	if ctx.Err() != nil { // checked before the first write only, see cancel.go
+		ctxFail(ctx, resp)
+		return resp
+	}
 	bkt := openBkt(tx, resp, req.BktName)
 	if bkt == nil {
 		return resp
 	}
 	for _, key := range req.Keys {
+		var before []byte
+		if ChangeLog {
+			if before = bkt.Get([]byte(key)); before == nil {
+				continue // nothing to delete, so no change to log
+			}
+		}
 		err := bkt.Delete([]byte(key))
 		if err != nil { // key not found does not return error
-			log.Println("delete error - ", key, err)
-			resp.Status = Fail
-			resp.Msg = "delete error - " + key
+			slog.Error("delete failed", "bkt", req.BktName, "key", key, "err", err)
+			resp.fail(boltErrCode(err), "delete error - "+key)
+			return resp
+		}
+		if !logChangeFail(tx, resp, ChangeDelete, req.BktName, []byte(key), before, nil) {
 			return resp
 		}
 	}
@@ -186,16 +238,47 @@ func Delete(tx *bolt.Tx, req *DeleteRequest) *Response {
 
 // Qry returns records that meet request FindConditions and in specified sort order.
 // See type SortKey and Op constants in kvftypes.go
-func Qry(tx *bolt.Tx, req *QryRequest) *Response {
+// If ResultMode is "count" or "exists", SortFlds are ignored and Response.Recs is not loaded.
+// If Limit > 0, only the first Limit recs in sorted order (key order if no SortFlds) are returned.
+// If Parallel > 1, key range is split and scanned by multiple goroutines (see parallel.go).
+func Qry(ctx context.Context, tx *bolt.Tx, req *QryRequest) *Response {
 
 	resp := new(Response)
+	if !validResultMode(resp, req.ResultMode) {
+		return resp
+	}
 	bkt := openBkt(tx, resp, req.BktName)
 	if bkt == nil {
 		return resp
 	}
+	if req.ResultMode != ResultRecs {
+		countOrExists(ctx, bkt.Cursor(), resp, req.StartKey, req.EndKey, req.FindConditions, req.ResultMode)
+		return resp
+	}
+
+	items, scanned, err := qryItems(ctx, bkt, req)
+	resp.scanned = scanned
+	if err != nil {
+		slog.Debug("qry stopped", "bkt", req.BktName, "scanned", scanned, "err", err)
+		ctxFail(ctx, resp)
+		return resp
+	}
+	loadQryRecs(resp, items)
+	return resp
+}
+
+// Func qryItems returns recs meeting req FindConditions in sorted order, and the number of recs scanned.
+// Item recs are refs to db vals, only valid inside tx.
+// If ctx is done before the scan and sort complete, ctx.Err() is returned.
+func qryItems(ctx context.Context, bkt *bolt.Bucket, req *QryRequest) ([]qryItem, int, error) {
+	if req.Parallel > 1 {
+		return qryParallel(ctx, bkt, req) // see parallel.go
+	}
 	csr := bkt.Cursor()
 
-	result := make(map[string][]byte, DefaultQryRespSize) // recs meeting criteria, map key is db Key, map value is db Value
+	if req.Limit > 0 && req.SortFlds != nil {
+		return qryTopN(ctx, csr, req) // bounded heap, see topn.go
+	}
 
 	var k, v []byte
 	if req.StartKey == "" {
@@ -204,97 +287,168 @@ func Qry(tx *bolt.Tx, req *QryRequest) *Response {
 		k, v = csr.Seek([]byte(req.StartKey))
 	}
 
-	keys := make([]string, 0, DefaultQryRespSize)
-	var keep bool
+	result := make([]qryItem, 0, DefaultQryRespSize) // recs meeting criteria
+	eval := newRecEval(req.FindConditions, req.SortFlds)
+	defer eval.release()
 
-	log.Println("qry find loop start")
+	var scanned int
+	slog.Debug("qry find loop start", "bkt", req.BktName)
 	for k != nil {
 		key := string(k)
 		if req.EndKey != "" && key > req.EndKey {
 			break
 		}
-		if req.FindConditions == nil {
-			keep = true
-		} else {
-			keep = recFind(v, req.FindConditions)
+		if canceled(ctx, scanned) {
+			return nil, scanned, ctx.Err()
 		}
+		scanned++
+		keep, sortVals := eval.eval(v, true) // each rec is parsed once, sort vals are extracted for kept recs
 		if keep {
-			result[key] = v
-			keys = append(keys, key)
+			result = append(result, qryItem{key: key, rec: v, sortVals: sortVals})
+			if req.Limit > 0 && len(result) == req.Limit { // no sort, so 1st Limit recs in key order are the result
+				break
+			}
 		}
 		k, v = csr.Next()
 	}
-	log.Println("qry find loop done")
+	slog.Debug("qry find loop done", "scanned", scanned, "matched", len(result))
 
 	if req.SortFlds != nil {
-		log.Println("qry sort start")
-		slices.SortFunc(keys, func(a, b string) int { // slices pkg added in Go 1.21
-			reca := result[a]
-			recb := result[b]
-			var n int
-			for _, sortkey := range req.SortFlds {
-				switch sortkey.Dir {
-				case AscStr, DescStr: // compare string flds
-					vala := recGetStr(reca, sortkey.Fld, StrToLower)
-					valb := recGetStr(recb, sortkey.Fld, StrToLower)
-					n = cmp.Compare(vala, valb)
-				case AscInt, DescInt: // compare int flds
-					vala := recGetInt(reca, sortkey.Fld)
-					valb := recGetInt(recb, sortkey.Fld)
-					n = cmp.Compare(vala, valb)
-				}
-				if n == 0 { // sort key values are equal
-					continue
-				}
-				if sortkey.Dir == DescStr || sortkey.Dir == DescInt {
-					n = n * -1
-				}
-				return n
-			}
-			return 0 // all sort key values are equal
-		})
-		log.Println("qry sort done")
+		slog.Debug("qry sort start")
+		if err := sortItems(ctx, result, req.SortFlds); err != nil { // see cancel.go
+			return nil, scanned, err
+		}
+		slog.Debug("qry sort done")
 	}
+	return result, scanned, nil
+}
 
-	// load response.Recs slice in order based on sorted order of keys
-	resp.Recs = make([][]byte, 0, len(keys))
-	for _, key := range keys {
-		v := result[key]
-		vcopy := make([]byte, len(v))
-		copy(vcopy, v) // ref to v are invalid outside tx, so copy (see note at top)
+// qryItem is a rec meeting Qry criteria, along with its sort values.
+type qryItem struct {
+	key      string
+	rec      []byte
+	sortVals []sortVal
+}
+
+// Func loadQryRecs loads resp.Recs with copies of item recs, in items order.
+func loadQryRecs(resp *Response, items []qryItem) {
+	resp.Count = len(items)
+	resp.Exists = resp.Count > 0
+	resp.Recs = make([][]byte, 0, len(items))
+	for _, item := range items {
+		vcopy := make([]byte, len(item.rec))
+		copy(vcopy, item.rec) // ref to v are invalid outside tx, so copy (see note at top)
 		resp.Recs = append(resp.Recs, vcopy)
 	}
 	resp.Status = Ok
-	return resp
 }
 
 // Bkt performs bucket requests such as "create" and "delete"
-func Bkt(tx *bolt.Tx, req *BktRequest) *Response {
+func Bkt(ctx context.Context, tx *bolt.Tx, req *BktRequest) *Response {
 
 	resp := new(Response)
+	if ctx.Err() != nil {
+		ctxFail(ctx, resp)
+		return resp
+	}
 	var err error
+	var op string // change log op
 	switch req.Operation {
 	case "create":
 		_, err = tx.CreateBucket([]byte(req.BktName))
+		op = ChangeBktCreate
 	case "delete":
 		err = tx.DeleteBucket([]byte(req.BktName))
+		op = ChangeBktDelete
+	default:
+		slog.Debug("invalid bkt operation", "operation", req.Operation)
+		resp.fail(CodeValidation, "Invalid Bkt Operation - "+req.Operation)
+		return resp
 	}
 	if err != nil {
-		log.Println("Bkt Operation Failed-" + req.Operation + "-" + req.BktName)
-		resp.Status = Fail
-		resp.Msg = "Bkt Operation Failed-" + req.Operation + "-" + req.BktName
+		slog.Info("bkt operation failed", "operation", req.Operation, "bkt", req.BktName, "err", err)
+		resp.fail(boltErrCode(err), "Bkt Operation Failed-"+req.Operation+"-"+req.BktName+" - "+err.Error())
+		return resp
+	}
+	if !logChangeFail(tx, resp, op, req.BktName, nil, nil, nil) {
 		return resp
 	}
 	resp.Status = Ok
 	return resp
 }
 
+// Func countOrExists scans the key range loading only resp.Count or resp.Exists.
+// No record values are copied. For "exists" the scan ends at the first match.
+func countOrExists(ctx context.Context, csr *bolt.Cursor, resp *Response, startKey, endKey string, conditions []FindCondition, mode string) {
+	var k, v []byte
+	if startKey == "" {
+		k, v = csr.First()
+	} else {
+		k, v = csr.Seek([]byte(startKey))
+	}
+	var eval *recEval
+	if conditions != nil {
+		eval = newRecEval(conditions, nil)
+		defer eval.release()
+	}
+	for k != nil {
+		if endKey != "" && string(k) > endKey {
+			break
+		}
+		if canceled(ctx, resp.scanned) {
+			ctxFail(ctx, resp)
+			return
+		}
+		resp.scanned++
+		if eval == nil || keepRec(eval, v) {
+			if mode == ResultExists { // Count is left 0, the scan stopped so it is not the match count
+				resp.Exists = true
+				break
+			}
+			resp.Count++
+		}
+		k, v = csr.Next()
+	}
+	if mode == ResultCount {
+		resp.Exists = resp.Count > 0
+	}
+	resp.Status = Ok
+}
+
+func keepRec(eval *recEval, v []byte) bool {
+	keep, _ := eval.eval(v, false)
+	return keep
+}
+
+func validResultMode(resp *Response, mode string) bool {
+	switch mode {
+	case ResultRecs, ResultCount, ResultExists:
+		return true
+	}
+	slog.Debug("invalid result mode", "mode", mode)
+	resp.fail(CodeValidation, "Invalid ResultMode - "+mode)
+	return false
+}
+
 func openBkt(tx *bolt.Tx, resp *Response, bktName string) *bolt.Bucket {
 	bkt := tx.Bucket([]byte(bktName))
 	if bkt == nil {
-		log.Println("Bkt Not Found - ", bktName)
-		resp.Status = Fail
-		resp.Msg = "Bkt Not Found - " + bktName
+		slog.Debug("bkt not found", "bkt", bktName)
+		resp.fail(CodeBktNotFound, "Bkt Not Found - "+bktName)
 	}
 	return bkt
 }
+
+// Func boltErrCode returns the Response Code for an error returned by a bolt func.
+func boltErrCode(err error) string {
+	switch {
+	case errors.Is(err, bolt.ErrBucketNotFound):
+		return CodeBktNotFound
+	case errors.Is(err, bolt.ErrBucketExists):
+		return CodeConflict
+	case errors.Is(err, bolt.ErrBucketNameRequired), errors.Is(err, bolt.ErrKeyRequired),
+		errors.Is(err, bolt.ErrKeyTooLarge), errors.Is(err, bolt.ErrValueTooLarge), errors.Is(err, bolt.ErrIncompatibleValue):
+		return CodeValidation
+	}
+	return CodeInternal
+}
@@ -0,0 +1,45 @@
+package kvf
+
+import (
+	"context"
+	"testing"
+)
+
+// Count and exists modes load only Count or Exists, exists stops at the 1st match and leaves Count 0.
+func TestResultModes(t *testing.T) {
+	db := openTestDB(t)
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	ctx := context.Background()
+	pa := []FindCondition{{Fld: "st", Op: Matches, ValStr: "PA"}}
+	none := []FindCondition{{Fld: "st", Op: Matches, ValStr: "TX"}}
+
+	tests := []struct {
+		name       string
+		resp       *Response
+		wantCount  int
+		wantExists bool
+	}{
+		{"qry count", Qry(ctx, tx, &QryRequest{BktName: "many", FindConditions: pa, ResultMode: ResultCount}), 168, true},
+		{"qry count no match", Qry(ctx, tx, &QryRequest{BktName: "many", FindConditions: none, ResultMode: ResultCount}), 0, false},
+		{"qry exists", Qry(ctx, tx, &QryRequest{BktName: "many", FindConditions: pa, ResultMode: ResultExists}), 0, true},
+		{"qry exists no match", Qry(ctx, tx, &QryRequest{BktName: "many", FindConditions: none, ResultMode: ResultExists}), 0, false},
+		{"getall count", GetAll(ctx, tx, &GetAllRequest{BktName: "few", ResultMode: ResultCount}), 2, true},
+		{"getall exists", GetAll(ctx, tx, &GetAllRequest{BktName: "few", ResultMode: ResultExists}), 0, true},
+		{"getall exists empty bkt", GetAll(ctx, tx, &GetAllRequest{BktName: "empty", ResultMode: ResultExists}), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.resp.Status != Ok {
+				t.Fatalf("status %d: %s", tt.resp.Status, tt.resp.Msg)
+			}
+			if tt.resp.Count != tt.wantCount || tt.resp.Exists != tt.wantExists || tt.resp.Recs != nil {
+				t.Errorf("count %d exists %v recs %d, want count %d exists %v no recs",
+					tt.resp.Count, tt.resp.Exists, len(tt.resp.Recs), tt.wantCount, tt.wantExists)
+			}
+		})
+	}
+}
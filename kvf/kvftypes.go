@@ -2,6 +2,8 @@
 
 package kvf
 
+import "encoding/json"
+
 // Response Status Values
 const (
 	Ok int = iota
@@ -17,13 +19,117 @@ var StatusTxt = map[int]string{
 
 // Response used for all requests
 type Response struct {
-	Status int      `json:"status"` // see constants above Ok, Warning, Fail
+	Status int      `json:"status"`         // see constants above Ok, Warning, Fail
+	Code   string   `json:"code,omitempty"` // set for Fail (and some Warnings), see Code constants in errors.go
 	Msg    string   `json:"msg"`
 	Recs   [][]byte `json:"recs"`   // for request responses with potentially more than 1 record
 	Rec    []byte   `json:"rec"`    // for requests that only return 1 record
 	PutCnt int      `json:"putCnt"` // number of records either added or replaced by Put operation
+	Count  int      `json:"count"`  // number of matching records for GetAll and Qry requests
+	Exists bool     `json:"exists"` // true if any record matched, set by GetAll and Qry requests
+
+	RequestID string `json:"requestId,omitempty"` // from request RequestIDHeader, or generated by server
+
+	scanned int // number of records read from db to build the response, not sent to client
+}
+
+// Scanned returns the number of records the handler read from the db, used for server metrics.
+// It is not encoded, so it is always 0 in client programs.
+func (r *Response) Scanned() int {
+	return r.scanned
+}
+
+// Api versions. Client sends requested version in VersionHeader, server echoes the version used for the Response.
+// Clients that do not send the header (older clients) receive Version1.
+const (
+	VersionHeader = "Kvf-Version"
+	Version1      = 1        // Response is json.Marshalled as is, Recs and Rec are base64 encoded strings
+	Version2      = 2        // Response is sent as RawResponse, Recs and Rec are embedded as raw json
+	APIVersion    = Version2 // latest version, requested by kvf.Run
+)
+
+// RequestIDHeader holds the id used to match server log entries to a request.
+// Server uses the id sent by the client (or generates one), echoes it in this response header and Response.RequestID.
+const RequestIDHeader = "X-Request-Id"
+
+// AdminTokenHeader holds the server adminToken for admin requests, see Shutdown in run.go.
+const AdminTokenHeader = "Kvf-Admin-Token"
+
+// RawResponse is the Version2 wire format of Response.
+// Records are stored as json, so they are embedded directly rather than base64 encoded.
+// This reduces payload size by about a third and clients only unmarshal each rec once.
+type RawResponse struct {
+	Status int               `json:"status"`
+	Code   string            `json:"code,omitempty"`
+	Msg    string            `json:"msg"`
+	Recs   []json.RawMessage `json:"recs"`
+	Rec    json.RawMessage   `json:"rec"`
+	PutCnt int               `json:"putCnt"`
+	Count  int               `json:"count"`
+	Exists bool              `json:"exists"`
+
+	RequestID string `json:"requestId,omitempty"`
 }
 
+// Raw returns Response in Version2 wire format. Recs are not copied.
+func (r *Response) Raw() *RawResponse {
+	raw := &RawResponse{
+		Status: r.Status,
+		Code:   r.Code,
+		Msg:    r.Msg,
+		Rec:    r.Rec,
+		PutCnt: r.PutCnt,
+		Count:  r.Count,
+		Exists: r.Exists,
+
+		RequestID: r.RequestID,
+	}
+	if r.Recs != nil {
+		raw.Recs = make([]json.RawMessage, len(r.Recs))
+		for i, rec := range r.Recs {
+			raw.Recs[i] = rec
+		}
+	}
+	return raw
+}
+
+// Response converts Version2 wire format back to Response, so client code works the same for all versions.
+func (raw *RawResponse) Response() *Response {
+	r := &Response{
+		Status: raw.Status,
+		Code:   raw.Code,
+		Msg:    raw.Msg,
+		Rec:    nullToNil(raw.Rec),
+		PutCnt: raw.PutCnt,
+		Count:  raw.Count,
+		Exists: raw.Exists,
+
+		RequestID: raw.RequestID,
+	}
+	if raw.Recs != nil {
+		r.Recs = make([][]byte, len(raw.Recs))
+		for i, rec := range raw.Recs {
+			r.Recs[i] = nullToNil(rec)
+		}
+	}
+	return r
+}
+
+// json null is loaded into json.RawMessage as "null" rather than nil
+func nullToNil(rec json.RawMessage) []byte {
+	if string(rec) == "null" {
+		return nil
+	}
+	return rec
+}
+
+// ResultMode values used in GetAllRequest.ResultMode and QryRequest.ResultMode
+const (
+	ResultRecs   = ""       // default, matching recs are returned in Response.Recs
+	ResultCount  = "count"  // only Response.Count is loaded, Response.Recs is not built
+	ResultExists = "exists" // only Response.Exists is loaded (Count is 0), scan stops at first match
+)
+
 // Constants used in QryRequest.SortFlds and by handlers.go Qry() sort logic
 const (
 	AscStr int = iota
@@ -76,9 +182,11 @@ type GetRequest struct {
 // GetAllRequest is used to get all records in bucket ordered by key.
 // Use StartKey/EndKey to get all records in a range.
 type GetAllRequest struct {
-	BktName  string `json:"bktName"`
-	StartKey string `json:"startKey"`
-	EndKey   string `json:"endKey"`
+	BktName    string `json:"bktName"`
+	StartKey   string `json:"startKey"`
+	EndKey     string `json:"endKey"`
+	ResultMode string `json:"resultMode"` // see ResultMode constants above, "count" or "exists" skip loading Recs
+	Stream     bool   `json:"stream"`     // if true, recs are streamed as NDJSON, see stream.go and RunStream
 }
 
 // GetOneRequest is used to get a specific record by Key.
@@ -115,4 +223,8 @@ type QryRequest struct {
 	SortFlds       []SortKey       `json:"sortFlds"`       // SortKey type defined in handlers.go, see Qry func
 	StartKey       string          `json:"startKey"`
 	EndKey         string          `json:"endKey"`
+	ResultMode     string          `json:"resultMode"` // see ResultMode constants above, "count" or "exists" skip loading Recs
+	Limit          int             `json:"limit"`      // if > 0, max number of recs returned (top N by SortFlds, or 1st N in key order)
+	Parallel       int             `json:"parallel"`   // if > 1, number of goroutines scanning key range (capped by MaxQryParallel)
+	Stream         bool            `json:"stream"`     // if true, recs are streamed as NDJSON, see stream.go and RunStream
 }
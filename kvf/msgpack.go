@@ -0,0 +1,753 @@
+// File msgpack.go is a small MessagePack (https://msgpack.org) encoder/decoder, used by MsgpackCodec.
+// Only what is needed for kvf Request/Response types is supported: nil, bool, ints, uints, floats,
+// strings, []byte (bin), slices, arrays, maps with string keys and structs. Ext types are not supported.
+// Structs are encoded as maps keyed by json tag name (same names as JSON), fields with json tag "-"
+// are skipped and "omitempty" is honored. When decoding, unknown map keys are skipped.
+
+package kvf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MessagePack format codes
+const (
+	mpNil      = 0xc0
+	mpFalse    = 0xc2
+	mpTrue     = 0xc3
+	mpBin8     = 0xc4
+	mpBin16    = 0xc5
+	mpBin32    = 0xc6
+	mpFloat32  = 0xca
+	mpFloat64  = 0xcb
+	mpUint8    = 0xcc
+	mpUint16   = 0xcd
+	mpUint32   = 0xce
+	mpUint64   = 0xcf
+	mpInt8     = 0xd0
+	mpInt16    = 0xd1
+	mpInt32    = 0xd2
+	mpInt64    = 0xd3
+	mpStr8     = 0xd9
+	mpStr16    = 0xda
+	mpStr32    = 0xdb
+	mpArray16  = 0xdc
+	mpArray32  = 0xdd
+	mpMap16    = 0xde
+	mpMap32    = 0xdf
+	mpFixMap   = 0x80 // 0x80 - 0x8f
+	mpFixArray = 0x90 // 0x90 - 0x9f
+	mpFixStr   = 0xa0 // 0xa0 - 0xbf
+	mpNegFix   = 0xe0 // 0xe0 - 0xff
+)
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+// msgpackMaxDepth limits nesting of arrays, maps and structs when decoding (same as encoding/json),
+// so a small body of nested arrays cannot overflow the goroutine stack and crash the server.
+const msgpackMaxDepth = 10000
+
+var errMsgpackDepth = fmt.Errorf("msgpack: exceeded max depth of %d", msgpackMaxDepth)
+
+// ----- ENCODE -----
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func msgpackMarshal(v any) ([]byte, error) {
+	e := &msgpackEncoder{buf: make([]byte, 0, 512)}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, mpNil)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, mpNil)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, mpTrue)
+		} else {
+			e.buf = append(e.buf, mpFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeUint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, mpFloat32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, mpFloat64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.writeStr(v.String())
+	case reflect.Slice:
+		if v.IsNil() { // same as json, nil slice is null
+			e.buf = append(e.buf, mpNil)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBin(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value) error {
+	e.writeLen(v.Len(), mpFixArray, 16, mpArray16, mpArray32)
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeMap(v reflect.Value) error {
+	if v.IsNil() {
+		e.buf = append(e.buf, mpNil)
+		return nil
+	}
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("msgpack: unsupported map key type %s", v.Type().Key())
+	}
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() }) // repeatable output
+	e.writeLen(len(keys), mpFixMap, 16, mpMap16, mpMap32)
+	for _, key := range keys {
+		e.writeStr(key.String())
+		if err := e.encode(v.MapIndex(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	fields := msgpackStructFields(v.Type())
+	cnt := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !isEmptyValue(v.FieldByIndex(f.index)) {
+			cnt++
+		}
+	}
+	e.writeLen(cnt, mpFixMap, 16, mpMap16, mpMap32)
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		e.writeStr(f.name)
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		e.writeUint(uint64(i))
+	case i >= -32:
+		e.buf = append(e.buf, byte(int8(i)))
+	case i >= math.MinInt8:
+		e.buf = append(e.buf, mpInt8, byte(int8(i)))
+	case i >= math.MinInt16:
+		e.buf = append(e.buf, mpInt16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(int16(i)))
+	case i >= math.MinInt32:
+		e.buf = append(e.buf, mpInt32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(int32(i)))
+	default:
+		e.buf = append(e.buf, mpInt64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(i))
+	}
+}
+
+func (e *msgpackEncoder) writeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		e.buf = append(e.buf, byte(u))
+	case u <= math.MaxUint8:
+		e.buf = append(e.buf, mpUint8, byte(u))
+	case u <= math.MaxUint16:
+		e.buf = append(e.buf, mpUint16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(u))
+	case u <= math.MaxUint32:
+		e.buf = append(e.buf, mpUint32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(u))
+	default:
+		e.buf = append(e.buf, mpUint64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, u)
+	}
+}
+
+func (e *msgpackEncoder) writeStr(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.buf = append(e.buf, mpFixStr|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, mpStr8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, mpStr16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, mpStr32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) writeBin(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, mpBin8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, mpBin16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, mpBin32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+// Func writeLen writes array or map header. fixMax is the number of lengths the fix format can hold.
+func (e *msgpackEncoder) writeLen(n int, fixCode byte, fixMax int, code16, code32 byte) {
+	switch {
+	case n < fixMax:
+		e.buf = append(e.buf, fixCode|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, code16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, code32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+}
+
+// ----- DECODE -----
+
+type msgpackDecoder struct {
+	data  []byte
+	pos   int
+	depth int // nesting of the value being decoded, see msgpackMaxDepth
+}
+
+func msgpackUnmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("msgpack: Unmarshal requires a non nil pointer")
+	}
+	d := &msgpackDecoder{data: data}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return errors.New("msgpack: unexpected data after value")
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	if d.pos >= len(d.data) {
+		return errMsgpackShort
+	}
+	if d.depth++; d.depth > msgpackMaxDepth {
+		return errMsgpackDepth
+	}
+	defer func() { d.depth-- }()
+	if d.data[d.pos] == mpNil {
+		d.pos++
+		v.SetZero()
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("msgpack: cannot decode into %s", v.Type())
+		}
+		x, err := d.decodeAny()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(x))
+	case reflect.Bool:
+		c, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		if c != mpTrue && c != mpFalse {
+			return fmt.Errorf("msgpack: expected bool, found 0x%x", c)
+		}
+		v.SetBool(c == mpTrue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := d.readInt64()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("msgpack: %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := d.readUint64()
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(u) {
+			return fmt.Errorf("msgpack: %d overflows %s", u, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := d.readFloat64()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		b, err := d.readStrOrBin()
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.readStrOrBin()
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte{}, b...)) // copy, data may be reused by caller
+			return nil
+		}
+		n, err := d.readArrayLen()
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.decode(slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Array:
+		n, err := d.readArrayLen()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if i >= v.Len() {
+				if _, err := d.decodeAny(); err != nil { // skip extra elements
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		return d.decodeMap(v)
+	case reflect.Struct:
+		return d.decodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeMap(v reflect.Value) error {
+	t := v.Type()
+	if t.Key().Kind() != reflect.String {
+		return fmt.Errorf("msgpack: unsupported map key type %s", t.Key())
+	}
+	n, err := d.readMapLen()
+	if err != nil {
+		return err
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, n))
+	}
+	for i := 0; i < n; i++ {
+		key, err := d.readStrOrBin()
+		if err != nil {
+			return err
+		}
+		val := reflect.New(t.Elem()).Elem()
+		if err := d.decode(val); err != nil {
+			return err
+		}
+		v.SetMapIndex(reflect.ValueOf(string(key)).Convert(t.Key()), val)
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeStruct(v reflect.Value) error {
+	fields := msgpackStructFields(v.Type())
+	n, err := d.readMapLen()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		key, err := d.readStrOrBin()
+		if err != nil {
+			return err
+		}
+		f := findMsgpackField(fields, string(key))
+		if f == nil {
+			if _, err := d.decodeAny(); err != nil { // skip unknown field
+				return err
+			}
+			continue
+		}
+		if err := d.decode(v.FieldByIndex(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Func decodeAny decodes next value into nil, bool, int64, uint64, float64, string, []byte, []any or map[string]any.
+func (d *msgpackDecoder) decodeAny() (any, error) {
+	if d.pos >= len(d.data) {
+		return nil, errMsgpackShort
+	}
+	if d.depth++; d.depth > msgpackMaxDepth {
+		return nil, errMsgpackDepth
+	}
+	defer func() { d.depth-- }()
+	c := d.data[d.pos]
+	switch {
+	case c == mpNil:
+		d.pos++
+		return nil, nil
+	case c == mpTrue || c == mpFalse:
+		d.pos++
+		return c == mpTrue, nil
+	case c <= 0x7f || c >= mpNegFix || (c >= mpInt8 && c <= mpInt64):
+		return d.readInt64()
+	case c >= mpUint8 && c <= mpUint64:
+		return d.readUint64()
+	case c == mpFloat32 || c == mpFloat64:
+		return d.readFloat64()
+	case c >= mpFixStr && c <= 0xbf, c >= mpStr8 && c <= mpStr32:
+		b, err := d.readStrOrBin()
+		return string(b), err
+	case c >= mpBin8 && c <= mpBin32:
+		b, err := d.readStrOrBin()
+		return append([]byte{}, b...), err
+	case c >= mpFixArray && c <= 0x9f, c == mpArray16, c == mpArray32:
+		n, err := d.readArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = d.decodeAny(); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case c >= mpFixMap && c <= 0x8f, c == mpMap16, c == mpMap32:
+		n, err := d.readMapLen()
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any, n)
+		for i := 0; i < n; i++ {
+			key, err := d.readStrOrBin()
+			if err != nil {
+				return nil, err
+			}
+			if m[string(key)], err = d.decodeAny(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%x", c)
+}
+
+func (d *msgpackDecoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errMsgpackShort
+	}
+	c := d.data[d.pos]
+	d.pos++
+	return c, nil
+}
+
+func (d *msgpackDecoder) readN(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, errMsgpackShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// Func readSize reads a big endian length/value of size bytes (1, 2, 4 or 8).
+func (d *msgpackDecoder) readSize(size int) (uint64, error) {
+	b, err := d.readN(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// Func readInteger returns the next integer. Signed formats return neg true for negative values.
+func (d *msgpackDecoder) readInteger() (neg bool, u uint64, err error) {
+	c, err := d.readByte()
+	if err != nil {
+		return false, 0, err
+	}
+	switch {
+	case c <= 0x7f:
+		return false, uint64(c), nil
+	case c >= mpNegFix:
+		return true, uint64(int64(int8(c))), nil
+	case c >= mpUint8 && c <= mpUint64:
+		u, err = d.readSize(1 << (c - mpUint8))
+		return false, u, err
+	case c >= mpInt8 && c <= mpInt64:
+		size := 1 << (c - mpInt8)
+		u, err = d.readSize(size)
+		if err != nil {
+			return false, 0, err
+		}
+		var i int64
+		switch size {
+		case 1:
+			i = int64(int8(u))
+		case 2:
+			i = int64(int16(u))
+		case 4:
+			i = int64(int32(u))
+		default:
+			i = int64(u)
+		}
+		return i < 0, uint64(i), nil
+	}
+	return false, 0, fmt.Errorf("msgpack: expected integer, found 0x%x", c)
+}
+
+func (d *msgpackDecoder) readInt64() (int64, error) {
+	neg, u, err := d.readInteger()
+	if err != nil {
+		return 0, err
+	}
+	if !neg && u > math.MaxInt64 {
+		return 0, fmt.Errorf("msgpack: %d overflows int64", u)
+	}
+	return int64(u), nil
+}
+
+func (d *msgpackDecoder) readUint64() (uint64, error) {
+	neg, u, err := d.readInteger()
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		return 0, fmt.Errorf("msgpack: %d overflows uint", int64(u))
+	}
+	return u, nil
+}
+
+func (d *msgpackDecoder) readFloat64() (float64, error) {
+	if d.pos >= len(d.data) {
+		return 0, errMsgpackShort
+	}
+	switch d.data[d.pos] {
+	case mpFloat32:
+		d.pos++
+		bits, err := d.readSize(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case mpFloat64:
+		d.pos++
+		bits, err := d.readSize(8)
+		return math.Float64frombits(bits), err
+	}
+	neg, u, err := d.readInteger() // ints are accepted for float fields
+	if neg {
+		return float64(int64(u)), err
+	}
+	return float64(u), err
+}
+
+// Func readStrOrBin returns str or bin bytes, refs to d.data (not copied).
+func (d *msgpackDecoder) readStrOrBin() ([]byte, error) {
+	c, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	var n uint64
+	switch {
+	case c >= mpFixStr && c <= 0xbf:
+		n = uint64(c & 0x1f)
+	case c == mpStr8 || c == mpBin8:
+		n, err = d.readSize(1)
+	case c == mpStr16 || c == mpBin16:
+		n, err = d.readSize(2)
+	case c == mpStr32 || c == mpBin32:
+		n, err = d.readSize(4)
+	default:
+		return nil, fmt.Errorf("msgpack: expected string, found 0x%x", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.readN(int(n))
+}
+
+func (d *msgpackDecoder) readArrayLen() (int, error) {
+	return d.readLen(mpFixArray, mpArray16, mpArray32, 1)
+}
+
+func (d *msgpackDecoder) readMapLen() (int, error) {
+	return d.readLen(mpFixMap, mpMap16, mpMap32, 2)
+}
+
+// Func readLen reads array/map header. Each element takes at least 1 byte, minBytes per entry
+// is used to reject lengths larger than the remaining data before anything is allocated.
+func (d *msgpackDecoder) readLen(fixCode, code16, code32 byte, minBytes int) (int, error) {
+	c, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	switch {
+	case c&0xf0 == fixCode:
+		n = uint64(c & 0x0f)
+	case c == code16:
+		n, err = d.readSize(2)
+	case c == code32:
+		n, err = d.readSize(4)
+	default:
+		return 0, fmt.Errorf("msgpack: expected array/map, found 0x%x", c)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n*uint64(minBytes) > uint64(len(d.data)-d.pos) {
+		return 0, errMsgpackShort
+	}
+	return int(n), nil
+}
+
+// ----- STRUCT FIELDS -----
+
+type msgpackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var msgpackFieldCache sync.Map // reflect.Type -> []msgpackField
+
+// Func msgpackStructFields returns exported fields of t, named using json tags.
+func msgpackStructFields(t reflect.Type) []msgpackField {
+	if cached, found := msgpackFieldCache.Load(t); found {
+		return cached.([]msgpackField)
+	}
+	fields := make([]msgpackField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, msgpackField{
+			name:      name,
+			index:     sf.Index,
+			omitEmpty: strings.Contains(opts, "omitempty"),
+		})
+	}
+	msgpackFieldCache.Store(t, fields)
+	return fields
+}
+
+// Func findMsgpackField matches exact name 1st, then case insensitive (same as encoding/json).
+func findMsgpackField(fields []msgpackField, name string) *msgpackField {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
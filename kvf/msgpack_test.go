@@ -0,0 +1,84 @@
+package kvf
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		req  any
+		out  any // pointer to a zero value of the req type
+	}{
+		{"qry", &QryRequest{
+			BktName:        "location",
+			FindConditions: []FindCondition{{Fld: "st", Op: Matches, ValStr: "PA"}, {Fld: "pop", Op: GreaterThan, ValInt: -5}},
+			SortFlds:       []SortKey{{Fld: "city", Dir: DescStr}},
+			StartKey:       "k0001",
+			EndKey:         "k0999",
+			Limit:          10,
+			Parallel:       4,
+			Stream:         true,
+		}, &QryRequest{}},
+		{"put", &PutRequest{
+			BktName:  "location",
+			KeyField: "id",
+			Recs:     [][]byte{[]byte(`{"id":"k0001","notes":["a","b"]}`), []byte(`{"id":"k0002"}`)},
+		}, &PutRequest{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := MsgpackCodec.Marshal(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := MsgpackCodec.Unmarshal(data, tt.out); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.req, tt.out) {
+				t.Errorf("round trip = %+v, want %+v", tt.out, tt.req)
+			}
+		})
+	}
+}
+
+func TestMsgpackTruncated(t *testing.T) {
+	req := &QryRequest{BktName: "location", FindConditions: []FindCondition{{Fld: "st", Op: Matches, ValStr: "PA"}}, Limit: 300}
+	data, err := MsgpackCodec.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(data); n++ {
+		var out QryRequest
+		if err := MsgpackCodec.Unmarshal(data[:n], &out); err == nil {
+			t.Errorf("Unmarshal of %d of %d bytes returned no error", n, len(data))
+		}
+	}
+}
+
+// nestedArrays returns a QryRequest map with unknown key "x" holding depth nested 1 element arrays.
+func nestedArrays(depth int) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{mpFixMap | 1, mpFixStr | 1, 'x'})
+	buf.Write(bytes.Repeat([]byte{mpFixArray | 1}, depth))
+	buf.WriteByte(mpNil)
+	return buf.Bytes()
+}
+
+func TestMsgpackMaxDepth(t *testing.T) {
+	var out QryRequest
+	if err := MsgpackCodec.Unmarshal(nestedArrays(100), &out); err != nil {
+		t.Errorf("depth 100: %v", err)
+	}
+	// about 20MB, deep enough to overflow the stack without the limit
+	if err := MsgpackCodec.Unmarshal(nestedArrays(20<<20), &out); !errors.Is(err, errMsgpackDepth) {
+		t.Errorf("depth 20M: err = %v, want %v", err, errMsgpackDepth)
+	}
+	var x any
+	if err := MsgpackCodec.Unmarshal(bytes.Repeat([]byte{mpFixArray | 1}, msgpackMaxDepth+1), &x); !errors.Is(err, errMsgpackDepth) {
+		t.Errorf("any: err = %v, want %v", err, errMsgpackDepth)
+	}
+}
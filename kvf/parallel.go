@@ -0,0 +1,181 @@
+// File parallel.go contains the opt-in parallel scan used by Qry when QryRequest.Parallel > 1.
+// The requested key range is split into sub ranges, each scanned by a goroutine with its own cursor
+// in the same read transaction and its own recEval (fastjson.Parser is not thread safe).
+// Partial results are merged in range order, so output is in key order when no SortFlds are requested.
+// Note - cursors in a Bolt read only tx only read mmap'd pages and do not change shared tx state,
+// so concurrent cursors on the same bucket are safe. Never scan a write tx this way.
+
+package kvf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"runtime"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var MaxQryParallel = runtime.NumCPU() // upper limit for QryRequest.Parallel, server pgm can override
+
+// keyRange is a sub range scanned by 1 goroutine.
+// Start is inclusive. End is exclusive, if End is nil the scan ends at QryRequest.EndKey (inclusive) or last key.
+type keyRange struct {
+	start []byte
+	end   []byte
+}
+
+// Func qryParallel works same as the serial qryItems logic, but scans key sub ranges concurrently.
+// If ctx is done, each goroutine stops its scan and ctx.Err() is returned.
+func qryParallel(ctx context.Context, bkt *bolt.Bucket, req *QryRequest) ([]qryItem, int, error) {
+	ranges := splitKeyRange(bkt, req, min(req.Parallel, MaxQryParallel))
+
+	parts := make([][]qryItem, len(ranges)) // result of each range, in range order
+	scannedParts := make([]int, len(ranges))
+	var wg sync.WaitGroup
+	slog.Debug("qry parallel scan start", "bkt", req.BktName, "ranges", len(ranges))
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i int, r keyRange) {
+			defer wg.Done()
+			parts[i], scannedParts[i] = scanKeyRange(ctx, bkt, r, req)
+		}(i, r)
+	}
+	wg.Wait()
+	slog.Debug("qry parallel scan done")
+	var scanned int
+	for _, n := range scannedParts {
+		scanned += n
+	}
+	if err := ctx.Err(); err != nil { // some ranges may have stopped early
+		return nil, scanned, err
+	}
+
+	var result []qryItem
+	switch {
+	case req.Limit > 0 && req.SortFlds != nil: // each range kept its own top N, now keep top N of those
+		h := newTopNHeap(req)
+		for _, part := range parts {
+			for _, item := range part {
+				h.offer(item, req.Limit)
+			}
+		}
+		result = h.sorted()
+	default:
+		for _, part := range parts { // ranges are in key order
+			result = append(result, part...)
+		}
+		if req.SortFlds != nil {
+			if err := sortItems(ctx, result, req.SortFlds); err != nil { // see cancel.go
+				return nil, scanned, err
+			}
+		}
+		if req.Limit > 0 && len(result) > req.Limit {
+			result = result[:req.Limit]
+		}
+	}
+	return result, scanned, nil
+}
+
+// Func scanKeyRange returns recs in r meeting req.FindConditions, and the number of recs scanned.
+// If req.Limit is set, at most Limit items are returned (best Limit if SortFlds are set).
+// The scan stops early if ctx is done, the caller checks ctx.Err().
+func scanKeyRange(ctx context.Context, bkt *bolt.Bucket, r keyRange, req *QryRequest) ([]qryItem, int) {
+	eval := newRecEval(req.FindConditions, req.SortFlds)
+	defer eval.release()
+
+	var h *topNHeap
+	if req.Limit > 0 && req.SortFlds != nil {
+		h = newTopNHeap(req)
+	}
+	result := make([]qryItem, 0, DefaultQryRespSize)
+
+	var scanned int
+	csr := bkt.Cursor()
+	for k, v := csr.Seek(r.start); k != nil; k, v = csr.Next() {
+		if r.end != nil && bytes.Compare(k, r.end) >= 0 {
+			break
+		}
+		key := string(k)
+		if r.end == nil && req.EndKey != "" && key > req.EndKey {
+			break
+		}
+		if canceled(ctx, scanned) {
+			break
+		}
+		scanned++
+		keep, sortVals := eval.eval(v, true)
+		if !keep {
+			continue
+		}
+		item := qryItem{key: key, rec: v, sortVals: sortVals}
+		if h != nil {
+			h.offer(item, req.Limit)
+			continue
+		}
+		result = append(result, item)
+		if req.Limit > 0 && req.SortFlds == nil && len(result) == req.Limit {
+			break
+		}
+	}
+	if h != nil {
+		return h.items, scanned // unordered, merged by qryParallel
+	}
+	return result, scanned
+}
+
+// Func splitKeyRange splits the requested key range into at most parts sub ranges.
+// Split keys are interpolated between the first and last key, treating the 8 bytes following
+// their common prefix as a number. Keys with a skewed distribution may produce uneven ranges.
+func splitKeyRange(bkt *bolt.Bucket, req *QryRequest, parts int) []keyRange {
+	csr := bkt.Cursor()
+	var first, last []byte
+	if req.StartKey == "" {
+		first, _ = csr.First()
+	} else {
+		first, _ = csr.Seek([]byte(req.StartKey))
+	}
+	if req.EndKey == "" {
+		last, _ = csr.Last()
+	} else {
+		last = []byte(req.EndKey)
+	}
+	if first == nil { // no keys in requested range
+		return nil
+	}
+	if bytes.Compare(first, last) >= 0 || parts < 2 {
+		return []keyRange{{start: first}}
+	}
+
+	prefixLen := 0
+	for prefixLen < len(first) && prefixLen < len(last) && first[prefixLen] == last[prefixLen] {
+		prefixLen++
+	}
+	lo := keyNum(first[prefixLen:])
+	hi := keyNum(last[prefixLen:])
+	step := (hi - lo) / uint64(parts)
+	if step == 0 {
+		return []keyRange{{start: first}}
+	}
+
+	ranges := make([]keyRange, 0, parts)
+	start := first
+	for i := 1; i < parts; i++ {
+		split := make([]byte, prefixLen+8)
+		copy(split, first[:prefixLen])
+		binary.BigEndian.PutUint64(split[prefixLen:], lo+step*uint64(i))
+		ranges = append(ranges, keyRange{start: start, end: split})
+		start = split
+	}
+	ranges = append(ranges, keyRange{start: start})
+	return ranges
+}
+
+// Func keyNum returns 1st 8 bytes of b as big endian number, missing bytes are treated as 0.
+func keyNum(b []byte) uint64 {
+	var buf [8]byte
+	copy(buf[:], b)
+	return binary.BigEndian.Uint64(buf[:])
+}
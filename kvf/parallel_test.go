@@ -0,0 +1,156 @@
+package kvf
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Func openTestDB returns a db in a temp dir with bkts "empty", "few" (2 recs) and "many".
+// Keys of "many" are k0000-k0499 plus "a" and "z9", so the key range is unevenly filled.
+// Each rec has the unique int "n" and "st" ("PA" for every 3rd rec, otherwise "NY").
+func openTestDB(t testing.TB) *bolt.DB {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	keys := []string{"a", "z9"}
+	for i := 0; i < 500; i++ {
+		keys = append(keys, fmt.Sprintf("k%04d", i))
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucket([]byte("empty")); err != nil {
+			return err
+		}
+		fill := func(name string, keys []string) error {
+			bkt, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for i, key := range keys {
+				st := "NY"
+				if i%3 == 0 {
+					st = "PA"
+				}
+				rec := fmt.Sprintf(`{"id":%q,"n":%d,"st":%q}`, key, i*7%len(keys), st)
+				if err := bkt.Put([]byte(key), []byte(rec)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := fill("few", []string{"k1", "k2"}); err != nil {
+			return err
+		}
+		return fill("many", keys)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Func qryKeys returns the keys of the qryItems result of req.
+func qryKeys(t *testing.T, db *bolt.DB, req *QryRequest) []string {
+	t.Helper()
+	var keys []string
+	err := db.View(func(tx *bolt.Tx) error {
+		items, _, err := qryItems(context.Background(), tx.Bucket([]byte(req.BktName)), req)
+		for _, item := range items {
+			keys = append(keys, item.key)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// Parallel scans must return the same recs in the same order as the serial scan.
+func TestQryParallelSameAsSerial(t *testing.T) {
+	db := openTestDB(t)
+	defer func(n int) { MaxQryParallel = n }(MaxQryParallel)
+	MaxQryParallel = 16 // so the test does not depend on NumCPU
+
+	pa := []FindCondition{{Fld: "st", Op: Matches, ValStr: "PA"}}
+	byN := []SortKey{{Fld: "n", Dir: AscInt}}
+	tests := []struct {
+		name string
+		req  QryRequest
+	}{
+		{"all", QryRequest{BktName: "many"}},
+		{"empty bkt", QryRequest{BktName: "empty"}},
+		{"fewer keys than workers", QryRequest{BktName: "few"}},
+		{"fewer keys than workers sorted", QryRequest{BktName: "few", SortFlds: []SortKey{{Fld: "n", Dir: DescInt}}}},
+		{"start key", QryRequest{BktName: "many", StartKey: "k0123"}},
+		{"end key", QryRequest{BktName: "many", EndKey: "k0321"}},
+		{"start and end key", QryRequest{BktName: "many", StartKey: "k0100", EndKey: "k0199"}},
+		{"start and end key not in bkt", QryRequest{BktName: "many", StartKey: "k01000", EndKey: "k01999"}},
+		{"start key after last", QryRequest{BktName: "many", StartKey: "zz"}},
+		{"end key before first", QryRequest{BktName: "many", EndKey: "0"}},
+		{"find", QryRequest{BktName: "many", FindConditions: pa}},
+		{"limit", QryRequest{BktName: "many", FindConditions: pa, Limit: 25}},
+		{"sort", QryRequest{BktName: "many", SortFlds: byN}},
+		{"sort desc start and end key", QryRequest{BktName: "many", StartKey: "k0050", EndKey: "k0450", SortFlds: []SortKey{{Fld: "n", Dir: DescInt}}}},
+		{"sort limit", QryRequest{BktName: "many", FindConditions: pa, SortFlds: byN, Limit: 10}},
+		{"sort ties", QryRequest{BktName: "many", SortFlds: []SortKey{{Fld: "st", Dir: DescStr}}}},
+		{"sort ties limit", QryRequest{BktName: "many", SortFlds: []SortKey{{Fld: "st", Dir: AscStr}}, Limit: 200}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serial := qryKeys(t, db, &tt.req)
+			for _, parallel := range []int{2, 3, 4, 16} {
+				req := tt.req
+				req.Parallel = parallel
+				if got := qryKeys(t, db, &req); !slices.Equal(got, serial) {
+					t.Errorf("parallel %d = %v, want %v", parallel, got, serial)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitKeyRange(t *testing.T) {
+	db := openTestDB(t)
+	tests := []struct {
+		name      string
+		req       QryRequest
+		parts     int
+		minRanges int
+		maxRanges int
+	}{
+		{"empty bkt", QryRequest{BktName: "empty"}, 4, 0, 0},
+		{"no keys in range", QryRequest{BktName: "many", StartKey: "zz"}, 4, 0, 0},
+		{"1 part", QryRequest{BktName: "many"}, 1, 1, 1},
+		{"few", QryRequest{BktName: "few"}, 16, 1, 16},
+		{"many", QryRequest{BktName: "many"}, 4, 4, 4},
+		{"start and end key", QryRequest{BktName: "many", StartKey: "k0100", EndKey: "k0199"}, 4, 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ranges []keyRange
+			db.View(func(tx *bolt.Tx) error {
+				ranges = splitKeyRange(tx.Bucket([]byte(tt.req.BktName)), &tt.req, tt.parts)
+				return nil
+			})
+			if len(ranges) < tt.minRanges || len(ranges) > tt.maxRanges {
+				t.Fatalf("%d ranges, want %d to %d", len(ranges), tt.minRanges, tt.maxRanges)
+			}
+			for i, r := range ranges { // ranges must be contiguous, the last one open ended
+				if i < len(ranges)-1 && string(r.end) != string(ranges[i+1].start) {
+					t.Errorf("range %d ends at %q, next starts at %q", i, r.end, ranges[i+1].start)
+				}
+				if i == len(ranges)-1 && r.end != nil {
+					t.Errorf("last range ends at %q, want nil", r.end)
+				}
+			}
+		})
+	}
+}
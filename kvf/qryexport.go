@@ -0,0 +1,258 @@
+// File qryexport.go contains QryExport, which writes the result of a Qry as CSV or NDJSON for use in
+// other tools (spreadsheets, other teams' loaders). Recs are written as the cursor advances, same as QryStream,
+// so the result is never held in memory unless SortFlds or Parallel require it.
+// Columns pick the fields written, with optional header names. Nested values (arrays and objects, ex. notes)
+// are flattened for csv, arrays of plain values are joined with JoinSep, or all nested values are json encoded.
+//   csv:    id,City,notes          ndjson (with columns): {"id":"k0001","City":"Lakewood","notes":["Note #1","Note #2"]}
+//           k0001,Lakewood,Note #1|Note #2
+
+package kvf
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/valyala/fastjson"
+	bolt "go.etcd.io/bbolt"
+)
+
+const CSVContentType = "text/csv; charset=utf-8"
+
+// QryExportRequest.Format values
+const (
+	FormatCSV    = "csv" // default
+	FormatNDJSON = "ndjson"
+)
+
+// QryExportRequest.Nested values, how csv writes array and object values
+const (
+	NestedJoin = "join" // default, arrays of strings/numbers/bools joined with JoinSep, other nested values json encoded
+	NestedJSON = "json" // all arrays and objects json encoded
+)
+
+const DefaultJoinSep = "|"
+
+// ExportColumn is 1 field written by QryExport.
+type ExportColumn struct {
+	Fld    string `json:"fld"`    // rec field, "a.b" for field b of nested object a
+	Header string `json:"header"` // csv header / ndjson field name, default Fld
+}
+
+// QryExportRequest is used to download the result of a Qry as CSV or NDJSON, see QryExport and RunQryExport.
+type QryExportRequest struct {
+	Qry      QryRequest     `json:"qry"`      // ResultMode and Stream are ignored
+	Format   string         `json:"format"`   // FormatCSV (default) or FormatNDJSON
+	Columns  []ExportColumn `json:"columns"`  // required for csv, ndjson writes whole recs if nil
+	NoHeader bool           `json:"noHeader"` // csv only, omit the header row
+	Nested   string         `json:"nested"`   // csv only, NestedJoin (default) or NestedJSON
+	JoinSep  string         `json:"joinSep"`  // NestedJoin separator, default DefaultJoinSep
+}
+
+// Func ContentType returns the http Content-Type of the export.
+func (req *QryExportRequest) ContentType() string {
+	if req.Format == FormatNDJSON {
+		return NDJSONContentType
+	}
+	return CSVContentType
+}
+
+// QryExport runs the Qry and writes the matching recs to w in the requested format.
+// Response.Count is the number of recs written, the csv header row is not counted.
+func QryExport(ctx context.Context, tx *bolt.Tx, req *QryExportRequest, w io.Writer) *Response {
+
+	resp := new(Response)
+	bkt := openBkt(tx, resp, req.Qry.BktName)
+	if bkt == nil {
+		return resp
+	}
+	x := newQryExporter(req, w)
+	defer x.release()
+
+	if req.Format == FormatNDJSON {
+		qryEach(ctx, bkt, &req.Qry, resp, func(rec []byte) bool {
+			if req.Columns == nil {
+				return writeStreamRec(w, resp, rec)
+			}
+			return x.writeLine(resp, rec)
+		})
+		return resp
+	}
+
+	if !req.NoHeader {
+		headers := make([]string, len(req.Columns))
+		for i, col := range x.cols {
+			headers[i] = col.Header
+		}
+		if err := x.csv.Write(headers); err != nil {
+			x.failed(resp, err)
+			return resp
+		}
+	}
+	qryEach(ctx, bkt, &req.Qry, resp, func(rec []byte) bool {
+		return x.writeRow(resp, x.row(rec))
+	})
+	x.csv.Flush()
+	if err := x.csv.Error(); err != nil && resp.Status == Ok {
+		x.failed(resp, err)
+	}
+	return resp
+}
+
+// qryExporter converts recs to csv rows or ndjson lines for 1 QryExport.
+type qryExporter struct {
+	req    *QryExportRequest
+	cols   []ExportColumn
+	paths  [][]string // Fld of each column split on "."
+	parser *fastjson.Parser
+	csv    *csv.Writer
+	vals   []string     // reused csv row
+	buf    bytes.Buffer // reused ndjson line
+	w      io.Writer
+}
+
+func newQryExporter(req *QryExportRequest, w io.Writer) *qryExporter {
+	x := &qryExporter{req: req, parser: parserPool.Get(), w: w, csv: csv.NewWriter(w)}
+	x.cols = make([]ExportColumn, len(req.Columns))
+	x.paths = make([][]string, len(req.Columns))
+	for i, col := range req.Columns {
+		if col.Header == "" {
+			col.Header = col.Fld
+		}
+		x.cols[i] = col
+		x.paths[i] = strings.Split(col.Fld, ".")
+	}
+	x.vals = make([]string, len(x.cols))
+	return x
+}
+
+func (x *qryExporter) release() {
+	parserPool.Put(x.parser)
+	x.parser = nil
+}
+
+// Func row returns the csv values of rec, reusing x.vals. Missing and null fields are "".
+// A rec that is not valid json gives a row of "" values.
+func (x *qryExporter) row(rec []byte) []string {
+	v, err := x.parser.ParseBytes(rec)
+	for i, path := range x.paths {
+		x.vals[i] = ""
+		if err == nil {
+			x.vals[i] = csvVal(v.Get(path...), x.req.Nested, x.joinSep())
+		}
+	}
+	return x.vals
+}
+
+func (x *qryExporter) joinSep() string {
+	if x.req.JoinSep == "" {
+		return DefaultJoinSep
+	}
+	return x.req.JoinSep
+}
+
+// Func csvVal returns v as a csv value. Strings are unquoted, numbers and bools are their json text.
+func csvVal(v *fastjson.Value, nested, sep string) string {
+	if v == nil {
+		return ""
+	}
+	switch v.Type() {
+	case fastjson.TypeNull:
+		return ""
+	case fastjson.TypeString:
+		return string(v.GetStringBytes())
+	case fastjson.TypeArray:
+		if nested == NestedJSON {
+			return v.String()
+		}
+		arr, _ := v.Array()
+		parts := make([]string, len(arr))
+		for i, elem := range arr {
+			if t := elem.Type(); t == fastjson.TypeArray || t == fastjson.TypeObject {
+				return v.String() // not a flat list, joining would lose the structure
+			}
+			parts[i] = csvVal(elem, nested, sep)
+		}
+		return strings.Join(parts, sep)
+	}
+	return v.String() // number, true, false or object
+}
+
+// Func writeRow writes 1 csv row and increments resp.Count.
+// If the write fails, resp is set to Fail and false is returned.
+func (x *qryExporter) writeRow(resp *Response, row []string) bool {
+	if err := x.csv.Write(row); err != nil {
+		x.failed(resp, err)
+		return false
+	}
+	resp.Count++
+	return true
+}
+
+// Func writeLine writes the Columns of rec as 1 ndjson object, in Columns order.
+// Values keep their json type, missing fields are null.
+func (x *qryExporter) writeLine(resp *Response, rec []byte) bool {
+	v, err := x.parser.ParseBytes(rec)
+	x.buf.Reset()
+	x.buf.WriteByte('{')
+	for i, col := range x.cols {
+		if i > 0 {
+			x.buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(col.Header)
+		x.buf.Write(name)
+		x.buf.WriteByte(':')
+		var fld *fastjson.Value
+		if err == nil {
+			fld = v.Get(x.paths[i]...)
+		}
+		if fld == nil {
+			x.buf.WriteString("null")
+		} else {
+			x.buf.Write(fld.MarshalTo(nil))
+		}
+	}
+	x.buf.WriteString("}\n")
+	if _, err := x.w.Write(x.buf.Bytes()); err != nil {
+		x.failed(resp, err)
+		return false
+	}
+	resp.Count++
+	return true
+}
+
+func (x *qryExporter) failed(resp *Response, err error) {
+	slog.Warn("qry export write failed", "err", err)
+	resp.fail(CodeInternal, "Export Write Failed - "+err.Error())
+}
+
+// RunQryExport runs a QryExportRequest on the server and copies the csv or ndjson result to w.
+// The returned Response Count is the number of recs written. If the server fails part way,
+// the Fail Response and an *Error are returned, w then holds an incomplete result.
+func RunQryExport(httpClient *http.Client, req QryExportRequest, w io.Writer) (*Response, error) {
+	ctx, cancel := withTimeout(context.Background())
+	defer cancel()
+	resp, err := post(ctx, httpClient, "qryexport", &req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != CSVContentType && !strings.HasPrefix(contentType, NDJSONContentType) {
+		// nothing was exported (ex. bkt not found), so body is a regular Response
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return checkResponse(resp, body)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return nil, err
+	}
+	return streamTrailers(resp)
+}
@@ -1,20 +1,26 @@
 // The rec.go file contains funcs that perform actions using a record - []byte.
 // Funcs recGetStr and recGetInt return a field's value from the record.
-// Func recFind determines if the record meets specified FindConditions.
+// Type recEval determines if the record meets specified FindConditions and extracts its sort values.
+// Each record is parsed once per query using a pooled fastjson.Parser, rather than once per field.
 
 package kvf
 
 import (
+	"bytes"
 	"cmp"
-	"log"
+	"log/slog"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/valyala/fastjson"
 )
 
 const StrToLower = true // optional parm used when calling recGetStr()
 
+var parserPool fastjson.ParserPool // parsers are reused across queries, see recEval
+
 // Func recGetStr returns the string value associated with a field in the record.
+// Parses the whole record, so only use when a single field value is needed (ex. Put key).
 func recGetStr(rec []byte, fld string, toLower ...bool) string {
 	val := fastjson.GetString(rec, fld)
 	if len(toLower) > 0 && toLower[0] {
@@ -24,30 +30,89 @@ func recGetStr(rec []byte, fld string, toLower ...bool) string {
 }
 
 // Func recGetInt returns the int value associated with a field in the record.
+// Parses the whole record, so only use when a single field value is needed.
 func recGetInt(rec []byte, fld string) int {
 	return fastjson.GetInt(rec, fld)
 }
 
-// NOTE - in recFind() string values are converted to lower case.
+// NOTE - in recEval string values are converted to lower case.
 // If this behaviour is not valid for your use case, code must be changed.
 
-// Func recFind determines if rec value(s) meet all find conditions.
-func recFind(rec []byte, conditions []FindCondition) bool {
+// sortVal holds 1 sort field value, extracted once per record before sorting.
+// The SortKey.Dir determines if str or n is used.
+type sortVal struct {
+	str string
+	n   int
+}
+
+// recEval evaluates records for a single query.
+// Create with newRecEval, then call eval for each record. Call release when done.
+// A recEval is not safe for concurrent use, each goroutine needs its own.
+type recEval struct {
+	parser     *fastjson.Parser
+	conditions []FindCondition
+	compareStr [][]byte // lower case ValStr of each condition
+	buf        []byte   // reused to hold lower case rec string value
+	sortFlds   []SortKey
+}
+
+func newRecEval(conditions []FindCondition, sortFlds []SortKey) *recEval {
+	e := &recEval{
+		parser:   parserPool.Get(),
+		sortFlds: sortFlds,
+	}
+	if conditions != nil {
+		e.conditions = conditions
+		e.compareStr = make([][]byte, len(conditions))
+		for i, condition := range conditions {
+			e.compareStr[i] = []byte(strings.ToLower(condition.ValStr))
+		}
+	}
+	return e
+}
+
+// Func release returns the parser to the pool, recEval must not be used afterwards.
+func (e *recEval) release() {
+	parserPool.Put(e.parser)
+	e.parser = nil
+}
+
+// Func eval parses rec once, then determines if rec meets all find conditions.
+// If conditions are met and withSort is true, rec's sort values are also returned.
+func (e *recEval) eval(rec []byte, withSort bool) (bool, []sortVal) {
+	val, err := e.parser.ParseBytes(rec)
+	if err != nil {
+		val = nil // same as fastjson.GetString, invalid json is treated as rec with no fields
+	}
+	if !e.find(val) {
+		return false, nil
+	}
+	if !withSort || e.sortFlds == nil {
+		return true, nil
+	}
+	return true, e.sortVals(val)
+}
+
+// Func find determines if parsed rec value(s) meet all find conditions.
+// String values are compared as lower case []byte to avoid allocating per rec.
+func (e *recEval) find(val *fastjson.Value) bool {
 	var conditionMet bool
-	var n int                        // compare result  1:greater, -1:less, 0:equal
-	var compareVal, recValStr string // only used for strings, to support StartsWith and Contains ops
-	for _, condition := range conditions {
+	var n int             // compare result  1:greater, -1:less, 0:equal
+	var recValStr []byte  // only used for strings, to support StartsWith and Contains ops
+	var compareVal []byte // lower case condition.ValStr
+	for i, condition := range e.conditions {
 		conditionMet = false
 		switch condition.Op {
 		case Contains, Matches, StartsWith, LessThanStr, GreaterThanStr: // string comparison
-			compareVal = strings.ToLower(condition.ValStr)
-			recValStr = recGetStr(rec, condition.Fld, StrToLower)
-			n = cmp.Compare(recValStr, compareVal)
+			e.buf = appendLower(e.buf[:0], val.GetStringBytes(condition.Fld))
+			recValStr = e.buf
+			compareVal = e.compareStr[i]
+			n = bytes.Compare(recValStr, compareVal)
 		case EqualTo, LessThan, GreaterThan: // int comparison
-			recVal := recGetInt(rec, condition.Fld)
+			recVal := val.GetInt(condition.Fld)
 			n = cmp.Compare(recVal, condition.ValInt)
 		default:
-			log.Println("invalid find op", condition.Op)
+			slog.Debug("invalid find op", "op", condition.Op)
 			return false
 		}
 		switch condition.Op {
@@ -64,17 +129,78 @@ func recFind(rec []byte, conditions []FindCondition) bool {
 				conditionMet = true
 			}
 		case StartsWith:
-			if strings.HasPrefix(recValStr, compareVal) {
+			if bytes.HasPrefix(recValStr, compareVal) {
 				conditionMet = true
 			}
 		case Contains:
-			if strings.Index(recValStr, compareVal) > -1 {
+			if bytes.Contains(recValStr, compareVal) {
 				conditionMet = true
 			}
 		}
 		if !conditionMet {
-			return false // condition was not met, end recFind
+			return false // condition was not met, end find
 		}
 	}
 	return true // no condition check returned false
 }
+
+// Func appendLower appends lower case s to dst. Only non-ascii values are converted with bytes.ToLower.
+func appendLower(dst, s []byte) []byte {
+	for _, c := range s {
+		if c >= utf8.RuneSelf {
+			return append(dst, bytes.ToLower(s)...)
+		}
+	}
+	for _, c := range s {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// Func sortVals extracts the value of each sort fld from parsed rec.
+// Strings are copied, parsed values are only valid until the parser is reused.
+func (e *recEval) sortVals(val *fastjson.Value) []sortVal {
+	vals := make([]sortVal, len(e.sortFlds))
+	for i, sortkey := range e.sortFlds {
+		switch sortkey.Dir {
+		case AscStr, DescStr:
+			vals[i].str = strings.ToLower(string(val.GetStringBytes(sortkey.Fld))) // string() makes the copy
+		case AscInt, DescInt:
+			vals[i].n = val.GetInt(sortkey.Fld)
+		}
+	}
+	return vals
+}
+
+// Func compareSortVals compares sort values of 2 recs, returns -1 if a sorts before b, 1 if after, 0 if equal.
+func compareSortVals(a, b []sortVal, sortFlds []SortKey) int {
+	var n int
+	for i, sortkey := range sortFlds {
+		switch sortkey.Dir {
+		case AscStr, DescStr: // compare string flds
+			n = cmp.Compare(a[i].str, b[i].str)
+		case AscInt, DescInt: // compare int flds
+			n = cmp.Compare(a[i].n, b[i].n)
+		}
+		if n == 0 { // sort key values are equal
+			continue
+		}
+		if sortkey.Dir == DescStr || sortkey.Dir == DescInt {
+			n = n * -1
+		}
+		return n
+	}
+	return 0 // all sort key values are equal
+}
+
+// Func compareItems orders qry items by sortFlds, ties are broken by key so results are repeatable.
+// Used by both the full sort (sortItems) and the top N heap, so a Limit result is the 1st Limit recs of the full sort.
+func compareItems(a, b qryItem, sortFlds []SortKey) int {
+	if n := compareSortVals(a.sortVals, b.sortVals, sortFlds); n != 0 {
+		return n
+	}
+	return cmp.Compare(a.key, b.key)
+}
@@ -3,57 +3,331 @@
 package kvf
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var BaseURL string = "http://localhost:8000/" // client pgm can override default if needed
 var Debug bool                                // set by client to turn on debugging
+var WireCodec Codec = JSONCodec               // client pgm can set to GobCodec or MsgpackCodec, see codec.go
+var APIKey string                             // sent as "Authorization: Bearer <APIKey>" if set, see server/auth.go
+var Timeout time.Duration                     // if > 0, limits each Run/RunStream call (incl. reading the response)
 
 // Run func executes the api request using the provided payload.
+// If the Response Status is Fail, the Response is returned along with an *Error (see errors.go),
+// so callers can use errors.Is(err, kvf.ErrBktNotFound) etc. Warning responses return a nil error.
+// Transport, read and decode failures return a nil Response.
 func Run(httpClient *http.Client, op string, payload interface{}) (*Response, error) {
-	reqUrl := BaseURL + op
-	jsonContent, err := json.Marshal(&payload) // -> []byte
+	return RunContext(context.Background(), httpClient, op, payload)
+}
 
-	if Debug {
-		log.Println("--- client sending ---")
-		log.Println(fmtJSON(jsonContent))
+// RunContext works same as Run, the request is abandoned when ctx is done (returns ctx.Err() wrapped by http.Client).
+// The server sees the closed connection and stops the request, see kvf/cancel.go.
+func RunContext(ctx context.Context, httpClient *http.Client, op string, payload interface{}) (*Response, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	resp, err := post(ctx, httpClient, op, payload)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	reqBody := bytes.NewReader(jsonContent) // -> io.Reader
-
-	req, err := http.NewRequest("POST", reqUrl, reqBody)
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, doErr := httpClient.Do(req)
-	defer func() {
-		if doErr == nil {
-			resp.Body.Close()
-		}
-	}()
-	if resp.StatusCode != http.StatusOK || doErr != nil {
-		log.Println("Request Failed, Status:", resp.StatusCode, " ", resp.Status, " - ", doErr, " --- XXX")
-		return nil, doErr
-	}
 	result, err := io.ReadAll(resp.Body) // -> []byte
 	if err != nil {
 		log.Println("Read Http Response.Body Failed:", err)
+		return nil, err
 	}
 
-	if Debug {
+	if Debug && WireCodec == JSONCodec {
 		log.Println("--- client receiving ---")
 		log.Println(fmtJSON(result))
 	}
 
-	kvfResp := new(Response)
-	err = json.Unmarshal(result, kvfResp)
+	return checkResponse(resp, result)
+}
+
+// Func checkResponse decodes body and returns an *Error if the request failed.
+// If a failed request did not return a Response (ex. proxy error, older server), the error is based on the http status.
+func checkResponse(resp *http.Response, body []byte) (*Response, error) {
+	kvfResp, err := decodeResponse(resp, body)
+	if resp.StatusCode != http.StatusOK && (err != nil || kvfResp.Status != Fail) {
+		log.Println("Request Failed, Status:", resp.Status)
+		return nil, &Error{Code: codeFor(resp.StatusCode), Msg: strings.TrimSpace(string(body)), HTTPStatus: resp.StatusCode, RequestID: resp.Header.Get(RequestIDHeader)}
+	}
+	if err != nil {
+		log.Println("Response Decode Failed:", err)
+		return nil, err
+	}
+	if kvfResp.Status == Fail {
+		return kvfResp, &Error{Code: kvfResp.Code, Msg: kvfResp.Msg, HTTPStatus: resp.StatusCode, RequestID: kvfResp.RequestID}
+	}
+	return kvfResp, nil
+}
 
+// Func decodeResponse unmarshals body using the codec and api version the server responded with.
+// Servers that do not support Version2 send a Version1 Response without the version header.
+func decodeResponse(resp *http.Response, body []byte) (*Response, error) {
+	codec, found := CodecFor(resp.Header.Get("Content-Type"))
+	if !found {
+		codec = JSONCodec // older servers do not set Content-Type
+	}
+	if codec != JSONCodec { // other codecs do not base64 encode recs, so RawResponse is not used
+		kvfResp := new(Response)
+		err := codec.Unmarshal(body, kvfResp)
+		return kvfResp, err
+	}
+	version, _ := strconv.Atoi(resp.Header.Get(VersionHeader))
+	if version >= Version2 {
+		raw := new(RawResponse)
+		if err := json.Unmarshal(body, raw); err != nil {
+			return nil, err
+		}
+		return raw.Response(), nil
+	}
+	kvfResp := new(Response)
+	err := json.Unmarshal(body, kvfResp)
 	return kvfResp, err
 }
 
+// RunStream func executes a GetAll or Qry request with Stream set to true.
+// Func fn is called for each rec as it is read from the response, rec is only valid during the call.
+// If fn returns an error, reading stops and the error is returned.
+// The returned Response contains Status, Msg and Count sent by the server after the last rec.
+func RunStream(httpClient *http.Client, op string, payload interface{}, fn func(rec []byte) error) (*Response, error) {
+	return RunStreamContext(context.Background(), httpClient, op, payload, fn)
+}
+
+// RunStreamContext works same as RunStream, reading stops when ctx is done.
+func RunStreamContext(ctx context.Context, httpClient *http.Client, op string, payload interface{}, fn func(rec []byte) error) (*Response, error) {
+	switch req := payload.(type) { // make sure server streams the response
+	case *GetAllRequest:
+		req.Stream = true
+	case *QryRequest:
+		req.Stream = true
+	case GetAllRequest:
+		req.Stream = true
+		payload = req
+	case QryRequest:
+		req.Stream = true
+		payload = req
+	case *ExportRequest, ExportRequest: // always streamed
+	default:
+		return nil, errors.New("RunStream only supports GetAllRequest, QryRequest and ExportRequest")
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	resp, err := post(ctx, httpClient, op, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), NDJSONContentType) {
+		// server did not stream (ex. bkt not found), so body is a regular Response
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		kvfResp, err := checkResponse(resp, body)
+		if err != nil {
+			return kvfResp, err
+		}
+		for _, rec := range kvfResp.Recs {
+			if err := fn(rec); err != nil {
+				return kvfResp, err
+			}
+		}
+		return kvfResp, nil
+	}
+
+	reader := bufio.NewReaderSize(resp.Body, 64*1024)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull { // rec larger than buffer, collect the rest of it
+			full := append([]byte(nil), line...)
+			var rest []byte
+			rest, err = reader.ReadBytes('\n')
+			line = append(full, rest...)
+		}
+		if len(line) > 1 {
+			if fnErr := fn(bytes.TrimSuffix(line, []byte{'\n'})); fnErr != nil {
+				return nil, fnErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println("Read Stream Failed:", err)
+			return nil, err
+		}
+	}
+
+	return streamTrailers(resp)
+}
+
+// Func streamTrailers returns the Response sent in the trailers of a streamed response.
+// Trailers are only available after the body has been read to EOF.
+func streamTrailers(resp *http.Response) (*Response, error) {
+	kvfResp := new(Response)
+	kvfResp.Status, _ = strconv.Atoi(resp.Trailer.Get(TrailerStatus))
+	kvfResp.Code = resp.Trailer.Get(TrailerCode)
+	kvfResp.RequestID = resp.Header.Get(RequestIDHeader)
+	kvfResp.Msg = resp.Trailer.Get(TrailerMsg)
+	kvfResp.Count, _ = strconv.Atoi(resp.Trailer.Get(TrailerCount))
+	kvfResp.Exists = kvfResp.Count > 0
+	if resp.Trailer.Get(TrailerStatus) == "" {
+		return kvfResp, errors.New("stream ended without status, response may be incomplete")
+	}
+	if kvfResp.Status == Fail {
+		return kvfResp, &Error{Code: kvfResp.Code, Msg: kvfResp.Msg, HTTPStatus: resp.StatusCode, RequestID: kvfResp.RequestID}
+	}
+	return kvfResp, nil
+}
+
+// Shutdown asks the server to shut down gracefully. In-flight requests finish, then the db is closed.
+// The adminToken must match the server's configured adminToken, or "" if APIKey has admin permission on "*".
+func Shutdown(httpClient *http.Client, adminToken string) (*Response, error) {
+	req, err := http.NewRequest("POST", BaseURL+"admin/shutdown", nil)
+	if err != nil {
+		return nil, err
+	}
+	if adminToken != "" {
+		req.Header.Add(AdminTokenHeader, adminToken)
+	}
+	setAuth(req)
+	req.Header.Add(VersionHeader, strconv.Itoa(APIVersion))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return checkResponse(resp, body)
+}
+
+// Backup downloads a consistent copy of the db from the server and writes it to w, gzip compressed if compress is set.
+// The adminToken works same as for Shutdown. The number of bytes written to w is returned.
+// If the server fails part way, the transfer is aborted and an error is returned, so w may hold a partial copy.
+func Backup(httpClient *http.Client, adminToken string, compress bool, w io.Writer) (int64, error) {
+	reqUrl := BaseURL + "admin/backup"
+	if compress {
+		reqUrl += "?gzip=true"
+	}
+	req, err := http.NewRequest("GET", reqUrl, nil)
+	if err != nil {
+		return 0, err
+	}
+	if adminToken != "" {
+		req.Header.Add(AdminTokenHeader, adminToken)
+	}
+	setAuth(req)
+	req.Header.Add(VersionHeader, strconv.Itoa(APIVersion))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		_, err = checkResponse(resp, body)
+		return 0, err
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// Func post sends the payload to the server, caller must close returned resp.Body.
+// Non 200 responses are returned without error, failed requests still send a Response (see checkResponse).
+func post(ctx context.Context, httpClient *http.Client, op string, payload interface{}) (*http.Response, error) {
+	reqUrl := BaseURL + op
+	content, err := WireCodec.Marshal(payload) // -> []byte
+	if err != nil {
+		log.Println("Marshal of request failed:", err)
+		return nil, err
+	}
+
+	if Debug && WireCodec == JSONCodec {
+		log.Println("--- client sending ---")
+		log.Println(fmtJSON(content))
+	}
+
+	var contentEncoding string
+	if RequestEncoding != "" && len(content) >= RequestCompressMin {
+		content, err = Compress(RequestEncoding, content)
+		if err != nil {
+			log.Println("Compress of request failed:", err)
+			return nil, err
+		}
+		contentEncoding = RequestEncoding
+	}
+
+	reqBody := bytes.NewReader(content) // -> io.Reader
+
+	req, err := http.NewRequestWithContext(ctx, "POST", reqUrl, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", WireCodec.ContentType())
+	req.Header.Add("Accept", WireCodec.ContentType())
+	if contentEncoding != "" {
+		req.Header.Add("Content-Encoding", contentEncoding)
+	}
+	return send(httpClient, req)
+}
+
+// Func send adds the headers common to all requests, sends req and wraps a compressed resp.Body with a decompressor.
+func send(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	req.Header.Add("Accept-Encoding", AcceptEncodings)      // set explicitly, so http.Transport does not decompress gzip itself
+	req.Header.Add(VersionHeader, strconv.Itoa(APIVersion)) // recs are returned as raw json, see RawResponse
+	setAuth(req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Println("Request Failed - ", err)
+		return nil, err
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+		zr, err := NewDecompressReader(encoding, resp.Body)
+		if err != nil {
+			log.Println("Response Decompress Failed:", encoding, err)
+			resp.Body.Close()
+			return nil, err
+		}
+		resp.Body = &decompressBody{ReadCloser: zr, body: resp.Body}
+	}
+	return resp, nil
+}
+
+// Func withTimeout applies Timeout to ctx, if set.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if Timeout > 0 {
+		return context.WithTimeout(ctx, Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Func setAuth adds the Authorization header if APIKey is set.
+func setAuth(req *http.Request) {
+	if APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+APIKey)
+	}
+}
+
 // format JSON in easy to view format
 func fmtJSON(jsonContent []byte) string {
 	var out bytes.Buffer
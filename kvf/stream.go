@@ -0,0 +1,159 @@
+// File stream.go contains the streaming versions of GetAll and Qry, used when request Stream is true.
+// Recs are written to w as NDJSON (1 rec per line) while the tx is open, rather than being copied
+// into Response.Recs. The returned Response only holds Status, Msg and Count, which the server
+// sends as http trailers after the last rec. See RunStream in run.go for the client side.
+// Note - the read tx stays open until the last rec is written, a slow reader keeps it open longer.
+
+package kvf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const NDJSONContentType = "application/x-ndjson"
+
+// Http trailer names used to send Response values after the last streamed rec.
+const (
+	TrailerStatus = "Kvf-Status"
+	TrailerCode   = "Kvf-Code"
+	TrailerMsg    = "Kvf-Msg"
+	TrailerCount  = "Kvf-Count"
+)
+
+// GetAllStream works same as GetAll, but writes recs to w as the cursor advances.
+// ResultMode is ignored, use GetAll for "count" and "exists".
+func GetAllStream(ctx context.Context, tx *bolt.Tx, req *GetAllRequest, w io.Writer) *Response {
+
+	resp := new(Response)
+	bkt := openBkt(tx, resp, req.BktName)
+	if bkt == nil {
+		return resp
+	}
+	csr := bkt.Cursor()
+
+	var k, v []byte
+	if req.StartKey == "" {
+		k, v = csr.First()
+	} else {
+		k, v = csr.Seek([]byte(req.StartKey))
+	}
+	for k != nil {
+		if req.EndKey != "" && string(k) > req.EndKey {
+			break
+		}
+		if canceled(ctx, resp.scanned) {
+			ctxFail(ctx, resp) // recs already written are followed by the Fail trailers
+			return resp
+		}
+		resp.scanned++
+		if !writeStreamRec(w, resp, v) {
+			return resp
+		}
+		k, v = csr.Next()
+	}
+	resp.Exists = resp.Count > 0
+	resp.Status = Ok
+	return resp
+}
+
+// QryStream works same as Qry, but writes recs to w.
+// If no SortFlds are specified and Parallel <= 1, recs are written as the cursor advances.
+// Otherwise matching recs must be collected and sorted first, then written.
+// ResultMode is ignored, use Qry for "count" and "exists".
+func QryStream(ctx context.Context, tx *bolt.Tx, req *QryRequest, w io.Writer) *Response {
+
+	resp := new(Response)
+	bkt := openBkt(tx, resp, req.BktName)
+	if bkt == nil {
+		return resp
+	}
+	qryEach(ctx, bkt, req, resp, func(rec []byte) bool {
+		return writeStreamRec(w, resp, rec)
+	})
+	return resp
+}
+
+// Func qryEach calls emit for each rec matching req, in result order, and sets resp Status.
+// Emit must increment resp.Count for each rec it writes (used for Limit), and return false to stop.
+// Used by QryStream and QryExport.
+func qryEach(ctx context.Context, bkt *bolt.Bucket, req *QryRequest, resp *Response, emit func(rec []byte) bool) {
+	if req.SortFlds != nil || req.Parallel > 1 {
+		items, scanned, err := qryItems(ctx, bkt, req)
+		resp.scanned = scanned
+		if err != nil {
+			ctxFail(ctx, resp)
+			return
+		}
+		for _, item := range items {
+			if !emit(item.rec) {
+				return
+			}
+		}
+		resp.Exists = resp.Count > 0
+		resp.Status = Ok
+		return
+	}
+
+	csr := bkt.Cursor()
+	var k, v []byte
+	if req.StartKey == "" {
+		k, v = csr.First()
+	} else {
+		k, v = csr.Seek([]byte(req.StartKey))
+	}
+	eval := newRecEval(req.FindConditions, nil)
+	defer eval.release()
+
+	for k != nil {
+		if req.EndKey != "" && string(k) > req.EndKey {
+			break
+		}
+		if canceled(ctx, resp.scanned) {
+			ctxFail(ctx, resp) // recs already written are followed by the Fail trailers
+			return
+		}
+		resp.scanned++
+		if keep, _ := eval.eval(v, false); keep {
+			if !emit(v) {
+				return
+			}
+			if req.Limit > 0 && resp.Count == req.Limit {
+				break
+			}
+		}
+		k, v = csr.Next()
+	}
+	resp.Exists = resp.Count > 0
+	resp.Status = Ok
+}
+
+// Func writeStreamRec writes rec as a single NDJSON line and increments resp.Count.
+// Recs containing newlines (ex. indented json) are compacted first.
+// If the write fails (ex. client went away), resp is set to Fail and false is returned.
+func writeStreamRec(w io.Writer, resp *Response, rec []byte) bool {
+	var err error
+	if bytes.IndexByte(rec, '\n') > -1 {
+		var buf bytes.Buffer
+		if err = json.Compact(&buf, rec); err == nil {
+			buf.WriteByte('\n')
+			_, err = w.Write(buf.Bytes())
+		}
+	} else {
+		if _, err = w.Write(rec); err == nil {
+			_, err = w.Write([]byte{'\n'})
+		}
+	}
+	if err != nil {
+		slog.Warn("stream write failed", "err", err)
+		resp.fail(CodeInternal, "Stream Write Failed - "+err.Error())
+		return false
+	}
+	resp.Count++
+	return true
+}
@@ -0,0 +1,42 @@
+// File tls.go contains the constructor of an *http.Client for servers using https (see server/config.go TLSConfig).
+// Client pgm passes the returned client to Run and sets BaseURL to the https address, ex.
+//   httpClient, err := kvf.NewTLSClient("certs/ca.pem", "certs/client.pem", "certs/client-key.pem")
+//   kvf.BaseURL = "https://localhost:8000/"
+
+package kvf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+)
+
+// NewTLSClient returns an *http.Client that verifies the server certificate using caFile
+// and, if certFile and keyFile are set, sends a client certificate (required by servers using mutual tls).
+// If caFile is "", the system CA pool is used.
+func NewTLSClient(caFile, certFile, keyFile string) (*http.Client, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no pem certificates found in " + caFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	return &http.Client{Transport: transport}, nil
+}
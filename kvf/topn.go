@@ -0,0 +1,95 @@
+// File topn.go contains the bounded heap used by Qry when both Limit and SortFlds are specified.
+// Rather than collecting every matching rec and sorting all of them, only the best Limit
+// candidates are kept while scanning. Memory and sort work scale with Limit, not match count.
+
+package kvf
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// topNHeap is a max heap, the root is the worst rec currently kept (last in sort order).
+type topNHeap struct {
+	items    []qryItem
+	sortFlds []SortKey
+}
+
+func newTopNHeap(req *QryRequest) *topNHeap {
+	return &topNHeap{
+		items:    make([]qryItem, 0, req.Limit+1),
+		sortFlds: req.SortFlds,
+	}
+}
+
+// Func compare orders items same as sortItems, see compareItems.
+func (h *topNHeap) compare(a, b qryItem) int {
+	return compareItems(a, b, h.sortFlds)
+}
+
+func (h *topNHeap) Len() int           { return len(h.items) }
+func (h *topNHeap) Less(i, j int) bool { return h.compare(h.items[i], h.items[j]) > 0 }
+func (h *topNHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *topNHeap) Push(x any)         { h.items = append(h.items, x.(qryItem)) }
+func (h *topNHeap) Pop() any {
+	last := len(h.items) - 1
+	item := h.items[last]
+	h.items = h.items[:last]
+	return item
+}
+
+// Func offer adds item if heap is not full or item sorts before current worst item.
+func (h *topNHeap) offer(item qryItem, limit int) {
+	if h.Len() < limit {
+		heap.Push(h, item)
+	} else if h.compare(item, h.items[0]) < 0 { // better than current worst, so replace it
+		h.items[0] = item
+		heap.Fix(h, 0)
+	}
+}
+
+// Func qryTopN returns the 1st req.Limit recs meeting req.FindConditions in req.SortFlds order.
+func qryTopN(ctx context.Context, csr *bolt.Cursor, req *QryRequest) ([]qryItem, int, error) {
+	h := newTopNHeap(req)
+	eval := newRecEval(req.FindConditions, req.SortFlds)
+	defer eval.release()
+
+	var k, v []byte
+	if req.StartKey == "" {
+		k, v = csr.First()
+	} else {
+		k, v = csr.Seek([]byte(req.StartKey))
+	}
+
+	var scanned int
+	slog.Debug("qry topN loop start", "bkt", req.BktName, "limit", req.Limit)
+	for k != nil {
+		key := string(k)
+		if req.EndKey != "" && key > req.EndKey {
+			break
+		}
+		if canceled(ctx, scanned) {
+			return nil, scanned, ctx.Err()
+		}
+		scanned++
+		if keep, sortVals := eval.eval(v, true); keep {
+			h.offer(qryItem{key: key, rec: v, sortVals: sortVals}, req.Limit)
+		}
+		k, v = csr.Next()
+	}
+	slog.Debug("qry topN loop done", "scanned", scanned)
+
+	return h.sorted(), scanned, nil
+}
+
+// Func sorted empties the heap, returning its items in sort order.
+func (h *topNHeap) sorted() []qryItem {
+	items := make([]qryItem, h.Len())
+	for i := h.Len() - 1; i >= 0; i-- { // popping returns worst 1st, so load from the back
+		items[i] = heap.Pop(h).(qryItem)
+	}
+	return items
+}
@@ -0,0 +1,31 @@
+package kvf
+
+import (
+	"slices"
+	"testing"
+)
+
+// The top N heap (Limit with SortFlds) must return the 1st N recs of the full sort, also when sort values tie.
+func TestQryTopNSameAsFullSort(t *testing.T) {
+	db := openTestDB(t)
+	sorts := map[string][]SortKey{
+		"unique":      {{Fld: "n", Dir: AscInt}},
+		"ties asc":    {{Fld: "st", Dir: AscStr}},
+		"ties desc":   {{Fld: "st", Dir: DescStr}},
+		"ties 2 flds": {{Fld: "st", Dir: DescStr}, {Fld: "missing", Dir: AscInt}},
+	}
+	for name, sortFlds := range sorts {
+		t.Run(name, func(t *testing.T) {
+			full := qryKeys(t, db, &QryRequest{BktName: "many", SortFlds: sortFlds})
+			for _, limit := range []int{1, 10, 167, 168, 501, 502, 1000} {
+				for _, parallel := range []int{0, 4} {
+					req := QryRequest{BktName: "many", SortFlds: sortFlds, Limit: limit, Parallel: parallel}
+					want := full[:min(limit, len(full))]
+					if got := qryKeys(t, db, &req); !slices.Equal(got, want) {
+						t.Errorf("limit %d parallel %d = %v, want %v", limit, parallel, got, want)
+					}
+				}
+			}
+		})
+	}
+}
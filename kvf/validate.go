@@ -0,0 +1,246 @@
+// File validate.go checks request values before a handler is called.
+// The server calls Validate after decoding each request, so invalid or oversized requests are rejected
+// before a db tx is started. Client programs can also call it to catch problems before sending.
+// Invalid values return an *Error with CodeValidation, exceeded Limits return CodeTooLarge.
+
+package kvf
+
+import (
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Limits on request sizes, 0 is no limit. The server loads them from its config (limits).
+type Limits struct {
+	MaxPutRecs    int // recs per PutRequest
+	MaxKeys       int // keys per GetRequest and DeleteRequest
+	MaxConditions int // FindConditions per QryRequest
+	MaxSortFlds   int // SortFlds per QryRequest
+	MaxRecBytes   int // size of each rec in PutRequest and PutOneRequest
+}
+
+// Validate returns an *Error if request has invalid values or exceeds limits, otherwise nil.
+// Request must be a pointer to one of the request types in kvftypes.go.
+func Validate(request any, limits Limits) error {
+	switch req := request.(type) {
+	case *GetRequest:
+		return firstErr(validBktName(req.BktName), validKeys(req.Keys, limits))
+	case *GetOneRequest:
+		return firstErr(validBktName(req.BktName), validKey(req.Key))
+	case *GetAllRequest:
+		return firstErr(validBktName(req.BktName), validMode(req.ResultMode))
+	case *PutRequest:
+		if err := firstErr(validWriteBkt(req.BktName), validKeyField(req.KeyField)); err != nil {
+			return err
+		}
+		if limits.MaxPutRecs > 0 && len(req.Recs) > limits.MaxPutRecs {
+			return tooLarge("%d recs exceeds limit of %d per put, send smaller batches", len(req.Recs), limits.MaxPutRecs)
+		}
+		for i, rec := range req.Recs {
+			if err := validRec(rec, limits); err != nil {
+				err.Msg = fmt.Sprintf("recs[%d] %s", i, err.Msg)
+				return err
+			}
+		}
+	case *PutOneRequest:
+		if err := firstErr(validWriteBkt(req.BktName), validKeyField(req.KeyField)); err != nil {
+			return err
+		}
+		if err := validRec(req.Rec, limits); err != nil {
+			return err
+		}
+	case *DeleteRequest:
+		return firstErr(validWriteBkt(req.BktName), validKeys(req.Keys, limits))
+	case *QryRequest:
+		return validQry(req, limits)
+	case *QryExportRequest:
+		return validQryExport(req, limits)
+	case *ExportRequest:
+		for _, name := range req.BktNames {
+			if err := validBktName(name); err != nil {
+				return err
+			}
+		}
+	case *BktRequest:
+		if req.Operation != "create" && req.Operation != "delete" {
+			return invalid("operation %q must be create or delete", req.Operation)
+		}
+		return firstErr(validWriteBkt(req.BktName))
+	case *ChangesRequest:
+		if req.Limit < 0 {
+			return invalid("limit must be >= 0")
+		}
+	case *WatchRequest:
+		return validWatch(req, limits)
+	default:
+		return invalid("unknown request type %T", request)
+	}
+	return nil
+}
+
+func validQry(req *QryRequest, limits Limits) error {
+	if err := firstErr(validBktName(req.BktName), validMode(req.ResultMode)); err != nil {
+		return err
+	}
+	if err := validConditions(req.FindConditions, limits); err != nil {
+		return err
+	}
+	if limits.MaxSortFlds > 0 && len(req.SortFlds) > limits.MaxSortFlds {
+		return tooLarge("%d sortFlds exceeds limit of %d", len(req.SortFlds), limits.MaxSortFlds)
+	}
+	for i, s := range req.SortFlds {
+		if s.Fld == "" {
+			return invalid("sortFlds[%d] fld is required", i)
+		}
+		if s.Dir < AscStr || s.Dir > DescInt {
+			return invalid("sortFlds[%d] dir %d is not a valid dir", i, s.Dir)
+		}
+	}
+	if req.Limit < 0 {
+		return invalid("limit must be >= 0")
+	}
+	if req.Parallel < 0 {
+		return invalid("parallel must be >= 0")
+	}
+	return nil
+}
+
+func validQryExport(req *QryExportRequest, limits Limits) error {
+	if err := validQry(&req.Qry, limits); err != nil {
+		return err
+	}
+	switch req.Format {
+	case "", FormatCSV:
+		if len(req.Columns) == 0 {
+			return invalid("columns are required for csv")
+		}
+	case FormatNDJSON:
+	default:
+		return invalid("format %q must be csv or ndjson", req.Format)
+	}
+	if req.Nested != "" && req.Nested != NestedJoin && req.Nested != NestedJSON {
+		return invalid("nested %q must be join or json", req.Nested)
+	}
+	for i, col := range req.Columns {
+		if col.Fld == "" {
+			return invalid("columns[%d] fld is required", i)
+		}
+	}
+	return nil
+}
+
+// Func validWatch also checks the change log settings, a watch needs the log and FindConditions need its rec values.
+func validWatch(req *WatchRequest, limits Limits) error {
+	if !ChangeLog {
+		return invalid("watch needs the change log, it is not enabled on the server")
+	}
+	if req.BktName != "" {
+		if err := validBktName(req.BktName); err != nil {
+			return err
+		}
+	}
+	if req.FindConditions != nil && ChangeLogValues == ChangeValuesNone {
+		return invalid("findConditions need change log values %s or %s, server has %s", ChangeValuesAfter, ChangeValuesBoth, ChangeLogValues)
+	}
+	return firstErr(validConditions(req.FindConditions, limits))
+}
+
+func validConditions(conditions []FindCondition, limits Limits) *Error {
+	if limits.MaxConditions > 0 && len(conditions) > limits.MaxConditions {
+		return tooLarge("%d findConditions exceeds limit of %d", len(conditions), limits.MaxConditions)
+	}
+	for i, c := range conditions {
+		if c.Fld == "" {
+			return invalid("findConditions[%d] fld is required", i)
+		}
+		if c.Op < Contains || c.Op > EqualTo {
+			return invalid("findConditions[%d] op %d is not a valid op", i, c.Op)
+		}
+	}
+	return nil
+}
+
+func validBktName(name string) *Error {
+	if name == "" {
+		return invalid("bktName is required")
+	}
+	if len(name) > bolt.MaxKeySize {
+		return invalid("bktName exceeds %d bytes", bolt.MaxKeySize)
+	}
+	return nil
+}
+
+// Func validWriteBkt is validBktName for requests that change the bkt, the change log bkt is reserved.
+func validWriteBkt(name string) *Error {
+	if name == ChangeLogBkt {
+		return invalid("bktName %s is reserved for the change log", ChangeLogBkt)
+	}
+	return validBktName(name)
+}
+
+func validKeyField(keyField string) *Error {
+	if keyField == "" {
+		return invalid("keyField is required")
+	}
+	return nil
+}
+
+func validKey(key string) *Error {
+	if key == "" {
+		return invalid("key is required")
+	}
+	if len(key) > bolt.MaxKeySize {
+		return invalid("key exceeds %d bytes", bolt.MaxKeySize)
+	}
+	return nil
+}
+
+func validKeys(keys []string, limits Limits) *Error {
+	if limits.MaxKeys > 0 && len(keys) > limits.MaxKeys {
+		return tooLarge("%d keys exceeds limit of %d per request", len(keys), limits.MaxKeys)
+	}
+	for i, key := range keys {
+		if err := validKey(key); err != nil {
+			err.Msg = fmt.Sprintf("keys[%d] %s", i, err.Msg)
+			return err
+		}
+	}
+	return nil
+}
+
+func validRec(rec []byte, limits Limits) *Error {
+	if len(rec) == 0 {
+		return invalid("rec is empty")
+	}
+	if limits.MaxRecBytes > 0 && len(rec) > limits.MaxRecBytes {
+		return tooLarge("rec size %d bytes exceeds limit of %d", len(rec), limits.MaxRecBytes)
+	}
+	return nil
+}
+
+func validMode(mode string) *Error {
+	switch mode {
+	case ResultRecs, ResultCount, ResultExists:
+		return nil
+	}
+	return invalid("resultMode %q must be \"\", count or exists", mode)
+}
+
+func invalid(format string, args ...any) *Error {
+	return &Error{Code: CodeValidation, Msg: fmt.Sprintf(format, args...)}
+}
+
+func tooLarge(format string, args ...any) *Error {
+	return &Error{Code: CodeTooLarge, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Func firstErr returns the first non nil err as an error, avoiding a non nil error holding a nil *Error.
+func firstErr(errs ...*Error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
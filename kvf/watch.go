@@ -0,0 +1,307 @@
+// File watch.go contains change subscriptions, which send change log entries (see changelog.go) to clients
+// as Server-Sent Events when they are committed, so clients do not have to poll with Qry.
+// A WatchRequest filters the entries by bkt, key prefix and FindConditions. The SSE id of each event is the
+// entry Seq, so a client that reconnects with the last id it received (Last-Event-ID header, or FromSeq id + 1)
+// continues without missing or repeating changes.
+//   id: 42
+//   event: change
+//   data: {"seq":42,"time":"2024-01-01T12:00:00.123Z","op":"put","bkt":"location","key":"k0001","after":{...}}
+//
+// The server /watch endpoint is in server/watch.go, client programs use RunWatch.
+
+package kvf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const WatchContentType = "text/event-stream"
+
+const WatchSeqHeader = "Kvf-Watch-Seq" // response header, seq of the 1st entry the watch can send
+
+// SSE event names
+const (
+	WatchEventChange = "change" // data is a ChangeEntry
+	WatchEventError  = "error"  // data is a Fail Response, the server then ends the stream
+)
+
+const watchBatch = 1000 // max entries read per Events call, keeps read txs short during a replay
+
+var WatchRetry = 2 * time.Second // RunWatch wait before reconnecting
+
+// WatchRequest subscribes to the changes that match all of its filters.
+type WatchRequest struct {
+	FromSeq        uint64          `json:"fromSeq"`        // 0 for changes committed after the watch starts, otherwise replay from this seq
+	BktName        string          `json:"bktName"`        // optional, only changes to this bkt
+	KeyPrefix      string          `json:"keyPrefix"`      // optional, only keys starting with this, bkt ops are not sent
+	FindConditions []FindCondition `json:"findConditions"` // optional, same as QryRequest, bkt ops are not sent, see Watcher.match
+}
+
+// changeSignal is closed and replaced when a tx that wrote change log entries commits, see ChangeSignal.
+var changeSignal = struct {
+	mu      sync.Mutex
+	ch      chan struct{}
+	waiting bool // ChangeSignal was called since ch was created
+}{ch: make(chan struct{})}
+
+// ChangeSignal returns a channel that is closed when the next change log entry is committed.
+// Get the channel before reading the log, so a commit during the read is not missed.
+func ChangeSignal() <-chan struct{} {
+	changeSignal.mu.Lock()
+	defer changeSignal.mu.Unlock()
+	changeSignal.waiting = true
+	return changeSignal.ch
+}
+
+// NotifyChanges wakes the watchers waiting on ChangeSignal. The server calls it once after each write tx
+// that may have logged changes commits, the channel is only replaced if someone is waiting on it.
+func NotifyChanges() {
+	changeSignal.mu.Lock()
+	defer changeSignal.mu.Unlock()
+	if changeSignal.waiting {
+		close(changeSignal.ch)
+		changeSignal.ch = make(chan struct{})
+		changeSignal.waiting = false
+	}
+}
+
+// Watcher writes the change log entries matching a WatchRequest as SSE events, 1 per watch connection.
+// Create with NewWatcher, call Events in a read tx each time ChangeSignal is closed, call Release when done.
+// A Watcher is not safe for concurrent use.
+type Watcher struct {
+	Seq    uint64 // next entry to read
+	req    *WatchRequest
+	eval   *recEval // nil if no FindConditions
+	lastID uint64   // last SSE id sent
+	idSent bool
+}
+
+// NewWatcher returns a Watcher starting at req.FromSeq, or after the last entry in tx if FromSeq is 0.
+func NewWatcher(tx *bolt.Tx, req *WatchRequest) *Watcher {
+	wr := &Watcher{Seq: req.FromSeq, req: req}
+	if req.FromSeq == 0 {
+		wr.Seq = 1
+		if changes := tx.Bucket([]byte(ChangeLogBkt)); changes != nil {
+			wr.Seq = changes.Sequence() + 1
+		}
+	}
+	if req.FindConditions != nil {
+		wr.eval = newRecEval(req.FindConditions, nil)
+	}
+	return wr
+}
+
+func (wr *Watcher) Release() {
+	if wr.eval != nil {
+		wr.eval.release()
+		wr.eval = nil
+	}
+}
+
+// Func Events appends the entries from Seq onward that match the WatchRequest to buf as change events,
+// reading at most watchBatch entries. Seq is advanced past the entries read. The id of the last entry read
+// is sent even if it did not match, so a client that reconnects does not read those entries again.
+// Response.Count is the number of events added. Call again without waiting while Seq advances.
+// The caller writes buf to the client after tx ends, so a slow client does not hold the read tx open
+// (an open read tx blocks the mmap remap of a write that grows the db file).
+func (wr *Watcher) Events(ctx context.Context, tx *bolt.Tx, buf *bytes.Buffer) *Response {
+
+	resp := new(Response)
+	resp.Status = Ok
+	if changes := tx.Bucket([]byte(ChangeLogBkt)); changes != nil {
+		csr := changes.Cursor()
+		var entry ChangeEntry
+		for k, v := csr.Seek(changeKey(wr.Seq)); k != nil && resp.scanned < watchBatch; k, v = csr.Next() {
+			if canceled(ctx, resp.scanned) {
+				ctxFail(ctx, resp)
+				return resp
+			}
+			resp.scanned++
+			seq, err := strconv.ParseUint(string(k), 10, 64)
+			if err != nil {
+				continue // not written by LogChange
+			}
+			wr.Seq = seq + 1
+			entry = ChangeEntry{}
+			if err := json.Unmarshal(v, &entry); err != nil {
+				slog.Warn("invalid change log entry", "seq", seq, "err", err)
+				continue
+			}
+			if !wr.match(&entry) {
+				continue
+			}
+			// entries are compact json (encoding/json compacts RawMessage values), so data is 1 line
+			fmt.Fprintf(buf, "id: %d\nevent: %s\ndata: %s\n\n", seq, WatchEventChange, v)
+			wr.lastID, wr.idSent = seq, true
+			resp.Count++
+		}
+	}
+	if !wr.idSent || wr.lastID != wr.Seq-1 { // an id without data sets Last-Event-ID, no event is dispatched
+		fmt.Fprintf(buf, "id: %d\n\n", wr.Seq-1)
+		wr.lastID, wr.idSent = wr.Seq-1, true
+	}
+	return resp
+}
+
+// Func match returns true if entry passes the WatchRequest filters.
+// With FindConditions, a put matches if its new value or (ChangeValuesBoth) its value before the put matches,
+// so watchers also see recs that stop matching. A delete matches if its before value (ChangeValuesBoth) matches.
+func (wr *Watcher) match(entry *ChangeEntry) bool {
+	if wr.req.BktName != "" && entry.BktName != wr.req.BktName {
+		return false
+	}
+	if entry.Key == "" { // bkt-create, bkt-delete
+		return wr.req.KeyPrefix == "" && wr.eval == nil
+	}
+	if !strings.HasPrefix(entry.Key, wr.req.KeyPrefix) {
+		return false
+	}
+	if wr.eval == nil {
+		return true
+	}
+	if entry.After != nil {
+		if found, _ := wr.eval.eval(entry.After, false); found {
+			return true
+		}
+	}
+	if entry.Before != nil {
+		found, _ := wr.eval.eval(entry.Before, false)
+		return found
+	}
+	return false
+}
+
+// WriteWatchError sends resp as an error event, used by the server before it ends a stream that failed.
+func WriteWatchError(w io.Writer, resp *Response) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", WatchEventError, data)
+	return err
+}
+
+// RunWatch sends the changes matching req to events until ctx is done, then closes events and returns ctx.Err().
+// If the stream ends (ex. server restart or op time limit), it reconnects after WatchRetry and continues after
+// the last id received, so no change is missed or repeated unless the change log was trimmed (ChangeLogMax) meanwhile.
+// With FromSeq 0, the seq the 1st connection started at (WatchSeqHeader) is used until an id is received.
+// A Fail Response with a 4xx http status (ex. forbidden, change log not enabled) ends the watch and is returned as an *Error,
+// after closing events. httpClient must not have a Timeout, it would end every stream, and kvf.Timeout is not used.
+func RunWatch(ctx context.Context, httpClient *http.Client, req WatchRequest, events chan<- ChangeEntry) error {
+	defer close(events)
+	for {
+		err := watchStream(ctx, httpClient, &req, events)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var kvfErr *Error
+		if errors.As(err, &kvfErr) && kvfErr.HTTPStatus >= 400 && kvfErr.HTTPStatus < 500 {
+			return err
+		}
+		log.Println("Watch Stream Ended, reconnecting:", err)
+		select {
+		case <-time.After(WatchRetry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Func watchStream reads 1 watch connection, sending change events to events.
+// req.FromSeq is set from each id received, so the next connection continues from there.
+func watchStream(ctx context.Context, httpClient *http.Client, req *WatchRequest, events chan<- ChangeEntry) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	resp, err := post(ctx, httpClient, "watch", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), WatchContentType) {
+		// watch was rejected, so body is a regular Response
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		_, err = checkResponse(resp, body)
+		if err == nil {
+			err = errors.New("watch not started, server did not send an event stream")
+		}
+		return err
+	}
+	if req.FromSeq == 0 { // "from now", reconnects must continue from where this watch started
+		if seq, err := strconv.ParseUint(resp.Header.Get(WatchSeqHeader), 10, 64); err == nil {
+			req.FromSeq = seq
+		}
+	}
+
+	reader := bufio.NewReaderSize(resp.Body, 64*1024)
+	var event string
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err // io.EOF if the server ended the stream
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" { // end of event
+			if err := dispatchWatchEvent(ctx, event, strings.Join(data, "\n"), events); err != nil {
+				return err
+			}
+			event, data = "", nil
+			continue
+		}
+		fld, val, _ := strings.Cut(line, ":")
+		val = strings.TrimPrefix(val, " ")
+		switch fld {
+		case "id":
+			if id, err := strconv.ParseUint(val, 10, 64); err == nil {
+				req.FromSeq = id + 1
+			}
+		case "event":
+			event = val
+		case "data":
+			data = append(data, val)
+		} // "" is a comment (ex. keep alive), retry and unknown fields are ignored
+	}
+}
+
+// Func dispatchWatchEvent sends a change event to events, an error event is returned as an *Error.
+func dispatchWatchEvent(ctx context.Context, event, data string, events chan<- ChangeEntry) error {
+	switch {
+	case data == "":
+		return nil // id only
+	case event == WatchEventError:
+		var resp Response
+		if err := json.Unmarshal([]byte(data), &resp); err != nil {
+			return fmt.Errorf("watch error event: %w", err)
+		}
+		return &Error{Code: resp.Code, Msg: resp.Msg, RequestID: resp.RequestID}
+	case event == WatchEventChange:
+		var entry ChangeEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return fmt.Errorf("watch change event: %w", err)
+		}
+		select {
+		case events <- entry:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -1,137 +1,325 @@
-// Program loader.go puts test data into "location" bucket.
-// Provides example of bulk data loader.
-// File is aprox 85,000 records and is used for all testing.
+// Program loader.go bulk loads a csv file into a bkt, driven by a mapping file (see mapping.go).
+// The mapping gives the column for each rec field, its type (int, float, bool, date, json), the key field,
+// defaults and rules for rows to skip. Rows that cannot be converted, and rows in a put request the server
+// rejected, are reported in a summary grouped by reason, and optionally written to a rejects csv file.
+// Recs are sent in put requests of batchSize recs by workers goroutines, so json decoding of the requests
+// runs in parallel on the server (only 1 bolt write tx can run at a time).
+// location.json loads the aprox 85,000 record test file used for all testing.
+//
+//	go run ./loader -mapping loader/location.json
+//	go run ./loader -mapping loader/location.json -dry-run -rejects /tmp/rejects.csv
+//
+// Exit status is 1 if any rows were rejected.
 
 package main
 
 import (
 	"encoding/csv"
-	"encoding/json"
-	"kvfun/core"
-	"kvfun/kvf"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-)
 
-var locationData []core.Location // loaded with data from csv file by loadData func below
+	"kvfun/kvf"
+)
 
 var httpClient *http.Client
 
-func main() {
-
-	// kvf.Debug = true
+const maxRejectExamples = 5 // rows listed per reason in the summary
 
-	var wg sync.WaitGroup
+// batch is 1 put request and the csv rows its recs came from, for reporting if the put fails
+type batch struct {
+	req     *kvf.PutRequest
+	rowNums []int
+	rows    [][]string
+}
 
-	loadData() // loads data from .csv into locationData slice
+// stats are updated by the reader and the workers, and logged by the progress reporter
+type stats struct {
+	read    atomic.Int64 // data rows read, not including the header
+	skipped atomic.Int64
+	put     atomic.Int64 // recs committed by the server
+	sent    atomic.Int64 // put requests sent
+}
 
-	var err error
-	var resp *kvf.Response
+// rejects collects rejected rows, grouped by reason
+type rejects struct {
+	mu      sync.Mutex
+	total   int
+	reasons map[string]*rejectReason
+	w       *csv.Writer // nil if -rejects is not set
+}
 
-	httpClient = new(http.Client)
+type rejectReason struct {
+	count   int
+	rowNums []int // first maxRejectExamples rows
+}
 
-	// DELETE / CREATE BUCKET ---------------------------------------
-	bktReq := kvf.BktRequest{BktName: "location", Operation: "delete"}
-	resp, _ = kvf.Run(httpClient, "bkt", bktReq)
+func main() {
+	mappingPath := flag.String("mapping", "", "mapping file (json), see loader/location.json")
+	csvPath := flag.String("csv", "", "csv file, overrides mapping csvFile")
+	url := flag.String("url", kvf.BaseURL, "server address")
+	key := flag.String("key", os.Getenv("KVF_API_KEY"), "api key, default env KVF_API_KEY")
+	dryRun := flag.Bool("dry-run", false, "convert and check rows, do not send them to the server")
+	rejectsPath := flag.String("rejects", "", "write rejected rows to this csv file, with row number and reason columns")
+	progress := flag.Duration("progress", 2*time.Second, "progress report interval, 0 for none")
+	flag.Parse()
+	if *mappingPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	bktReq.Operation = "create"
-	resp, err = kvf.Run(httpClient, "bkt", bktReq)
+	m, err := loadMapping(*mappingPath)
+	if err != nil {
+		log.Fatalln("mapping file invalid:", err)
+	}
+	if *csvPath != "" {
+		m.CSVFile = *csvPath
+	}
+	file, err := os.Open(m.CSVFile)
 	if err != nil {
-		log.Fatalln("bkt create failed", err, resp.Msg)
+		log.Fatalln("open csv file failed", err)
 	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = []rune(m.Delimiter)[0]
+	reader.FieldsPerRecord = -1 // short rows are rejected by rec, not by the reader
 
-	// PUT RECORDS INTO BUCKET -------------------------------------
+	var header []string
+	if m.Header {
+		if header, err = reader.Read(); err != nil {
+			log.Fatalln("read csv header failed", err)
+		}
+	}
+	if err := m.resolve(header); err != nil {
+		log.Fatalln("mapping file invalid:", err)
+	}
 
-	// upload records to db in batches of batchSize records, using goroutines
-	batchSize := 1000
-	putReq := newPutReq(batchSize)
-	for _, rec := range locationData {
-		jsonRec, err := json.Marshal(rec) // convert each record to []byte
+	rj := &rejects{reasons: make(map[string]*rejectReason)}
+	if *rejectsPath != "" {
+		f, err := os.Create(*rejectsPath)
 		if err != nil {
-			log.Fatalln("json.Marshal failed", err)
+			log.Fatalln("create rejects file failed", err)
 		}
-		putReq.Recs = append(putReq.Recs, jsonRec)
-		if len(putReq.Recs) == batchSize {
-			wg.Add(1)
-			go run(putReq, &wg)
-			putReq = newPutReq(batchSize)
-			time.Sleep(10 * time.Millisecond) // pause may be appropriate for large number of requests
+		defer f.Close()
+		rj.w = csv.NewWriter(f)
+		if header != nil {
+			rj.w.Write(append([]string{"row", "reason"}, header...))
 		}
 	}
-	if len(putReq.Recs) > 0 {
+
+	httpClient = new(http.Client)
+	if !strings.HasSuffix(*url, "/") {
+		*url += "/"
+	}
+	kvf.BaseURL = *url
+	kvf.APIKey = *key
+	if !*dryRun {
+		prepareBkt(m)
+	}
+
+	var st stats
+	start := time.Now()
+	stopProgress := reportProgress(&st, rj, start, *progress)
+
+	// workers send the batches, the channel buffer keeps them busy while the next batch is built
+	batches := make(chan *batch, m.Workers)
+	var wg sync.WaitGroup
+	for i := 0; i < m.Workers; i++ {
 		wg.Add(1)
-		go run(putReq, &wg)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				put(b, &st, rj)
+			}
+		}()
+	}
+
+	var rowNum int // csv line number of the row, the header is line 1
+	var recCnt int
+	b := newBatch(m)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				log.Fatalln("read csv failed", err)
+			}
+			rowNum = parseErr.StartLine
+			st.read.Add(1)
+			rj.add(rowNum, row, fmt.Errorf("csv: %w", parseErr.Err))
+			continue
+		}
+		rowNum, _ = reader.FieldPos(0)
+		st.read.Add(1)
+		if m.skipRule(row) >= 0 {
+			st.skipped.Add(1)
+			continue
+		}
+		rec, err := m.rec(row, recCnt)
+		if err != nil {
+			rj.add(rowNum, row, err)
+			continue
+		}
+		recCnt++
+		if *dryRun {
+			continue
+		}
+		b.req.Recs = append(b.req.Recs, rec)
+		b.rowNums = append(b.rowNums, rowNum)
+		b.rows = append(b.rows, row)
+		if len(b.req.Recs) == m.BatchSize {
+			batches <- b
+			b = newBatch(m)
+		}
 	}
+	if len(b.req.Recs) > 0 {
+		batches <- b
+	}
+	close(batches)
+	wg.Wait() // wait for all puts to finish before reporting
+	stopProgress()
 
-	wg.Wait() // wait for all runs to finish before ending program
+	elapsed := time.Since(start).Round(time.Millisecond)
+	log.Printf("done in %s - rows %d, recs put %d, skipped %d, rejected %d, put requests %d",
+		elapsed, st.read.Load(), st.put.Load(), st.skipped.Load(), rj.total, st.sent.Load())
+	if *dryRun {
+		log.Printf("dry run - %d recs converted, nothing sent", recCnt)
+	}
+	if rj.total > 0 {
+		rj.summary()
+		if rj.w != nil {
+			rj.w.Flush()
+			if err := rj.w.Error(); err != nil {
+				log.Println("write rejects file failed", err)
+			}
+		}
+		os.Exit(1)
+	}
 }
 
-func newPutReq(batchSize int) *kvf.PutRequest {
-	return &kvf.PutRequest{
-		BktName:  "location",
-		KeyField: "id",
-		Recs:     make([][]byte, 0, batchSize),
+// Func prepareBkt deletes and creates the bkt if RecreateBkt is set, otherwise creates it if missing.
+func prepareBkt(m *Mapping) {
+	bktReq := kvf.BktRequest{BktName: m.BktName, Operation: "delete"}
+	if m.RecreateBkt {
+		kvf.Run(httpClient, "bkt", bktReq) // bkt may not exist
+	}
+	bktReq.Operation = "create"
+	_, err := kvf.Run(httpClient, "bkt", bktReq)
+	var kvfErr *kvf.Error
+	if err != nil && !(errors.As(err, &kvfErr) && kvfErr.Code == kvf.CodeConflict) {
+		log.Fatalln("bkt create failed", err)
 	}
 }
 
-func loadData() {
-	var filePath = "/home/jay/data/properties.csv"
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Fatalln("open csv file failed", err)
+func newBatch(m *Mapping) *batch {
+	return &batch{
+		req: &kvf.PutRequest{
+			BktName:  m.BktName,
+			KeyField: m.KeyField,
+			Recs:     make([][]byte, 0, m.BatchSize),
+		},
+		rowNums: make([]int, 0, m.BatchSize),
+		rows:    make([][]string, 0, m.BatchSize),
 	}
-	reader := csv.NewReader(file)
-	csvRecs, err := reader.ReadAll()
+}
 
-	locationData = make([]core.Location, 0, len(csvRecs))
+// Func put sends 1 batch. If the server rejects it, nothing in the batch is committed,
+// so all of its rows are rejected with the server's reason.
+func put(b *batch, st *stats, rj *rejects) {
+	st.sent.Add(1)
+	resp, err := kvf.Run(httpClient, "put", b.req)
+	if err == nil {
+		st.put.Add(int64(resp.PutCnt))
+		return
+	}
+	reason := fmt.Errorf("put failed: %w", err)
+	for i, rowNum := range b.rowNums {
+		rj.add(rowNum, b.rows[i], reason)
+	}
+	log.Printf("put of rows %d-%d failed: %v", b.rowNums[0], b.rowNums[len(b.rowNums)-1], err)
+}
 
-	var x int // used to provide random values
-	for i, csvRec := range csvRecs {
-		if i == 0 { // skip header
-			continue
-		}
-		if csvRec[0] == "" {
-			continue
-		}
-		locRec := core.Location{
-			Id:      csvRec[0],
-			Address: csvRec[1],
-			City:    csvRec[2],
-			St:      csvRec[3],
-			Zip:     csvRec[4],
-			Notes: []string{
-				"Note #1",
-				"Note #2",
-			},
-		}
-		if x < 100 {
-			locRec.LocationType = 1
-			locRec.LastActionDt = "2021-03-22"
-		} else if x < 200 {
-			locRec.LocationType = 2
-			locRec.LastActionDt = "2022-06-10"
-		} else if x < 300 {
-			locRec.LocationType = 3
-			locRec.LastActionDt = "2023-09-01"
-		} else {
-			x = 0
+// Func reportProgress logs the counts every interval until the returned func is called.
+func reportProgress(st *stats, rj *rejects, start time.Time, interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				secs := time.Since(start).Seconds()
+				rj.mu.Lock()
+				rejected := rj.total
+				rj.mu.Unlock()
+				log.Printf("progress - rows %d (%.0f/s), recs put %d, skipped %d, rejected %d",
+					st.read.Load(), float64(st.read.Load())/secs, st.put.Load(), st.skipped.Load(), rejected)
+			case <-done:
+				return
+			}
 		}
-		x++
-		locationData = append(locationData, locRec)
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
 	}
 }
 
-func run(req *kvf.PutRequest, wg *sync.WaitGroup) {
-	defer wg.Done()
-	resp, err := kvf.Run(httpClient, "put", req)
-	if err != nil {
-		log.Fatalln("put req failed", err)
+// Func add records a rejected row. Rows are grouped by reason, without the rejected value.
+func (rj *rejects) add(rowNum int, row []string, reason error) {
+	group := reason.Error()
+	var re *rejectErr
+	if errors.As(reason, &re) {
+		group = re.Fld + ": " + re.Kind
+	}
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+	rj.total++
+	r := rj.reasons[group]
+	if r == nil {
+		r = &rejectReason{}
+		rj.reasons[group] = r
+	}
+	r.count++
+	if len(r.rowNums) < maxRejectExamples {
+		r.rowNums = append(r.rowNums, rowNum)
+	}
+	if rj.w != nil {
+		rj.w.Write(append([]string{strconv.Itoa(rowNum), reason.Error()}, row...))
 	}
-	if resp.Status != kvf.Ok {
-		log.Fatalln("ERROR", kvf.StatusTxt[resp.Status], resp.Msg)
+}
+
+// Func summary logs the rejected row counts by reason, most frequent first.
+func (rj *rejects) summary() {
+	groups := make([]string, 0, len(rj.reasons))
+	for group := range rj.reasons {
+		groups = append(groups, group)
+	}
+	slices.SortFunc(groups, func(a, b string) int {
+		return rj.reasons[b].count - rj.reasons[a].count
+	})
+	log.Printf("rejected rows: %d", rj.total)
+	for _, group := range groups {
+		r := rj.reasons[group]
+		rows := fmt.Sprint(r.rowNums)
+		if r.count > len(r.rowNums) {
+			rows = rows[:len(rows)-1] + " ...]"
+		}
+		log.Printf("  %6d  %s  rows %s", r.count, group, rows)
 	}
 }
@@ -0,0 +1,289 @@
+// File mapping.go contains the mapping file types and the funcs that turn a csv row into a json rec.
+// See location.json for the mapping that loads the "location" test data.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mapping describes how the rows of 1 csv file are loaded into a bkt.
+type Mapping struct {
+	CSVFile     string         `json:"csvFile"`   // can be overridden by the -csv flag
+	Header      bool           `json:"header"`    // first row has column names, used by Field.Col and SkipRule.Col
+	Delimiter   string         `json:"delimiter"` // default ","
+	BktName     string         `json:"bktName"`
+	KeyField    string         `json:"keyField"`    // rec field used as key, must be a required string Field with a column
+	RecreateBkt bool           `json:"recreateBkt"` // delete and create the bkt before loading, otherwise recs are added/replaced
+	BatchSize   int            `json:"batchSize"`   // recs per put request, default 1000
+	Workers     int            `json:"workers"`     // concurrent put requests, default 4
+	Fields      []Field        `json:"fields"`
+	Defaults    map[string]any `json:"defaults"` // values added to every rec, ex. "notes": ["Note #1"], Fields replace them
+	Skip        []SkipRule     `json:"skip"`     // rows matching any rule are skipped, not rejected
+}
+
+// Field maps 1 csv column (or generated value) to a rec field.
+type Field struct {
+	Fld        string `json:"fld"`        // json field name, "a.b" puts b in nested object a
+	Col        string `json:"col"`        // column name, requires Mapping.Header
+	Idx        *int   `json:"idx"`        // 0 based column index, used if Col is ""
+	Type       string `json:"type"`       // string (default), int, float, bool, date or json
+	Format     string `json:"format"`     // date: Go layout of the csv value, default 2006-01-02
+	OutFormat  string `json:"outFormat"`  // date: Go layout stored in the rec, default 2006-01-02
+	Required   bool   `json:"required"`   // an empty value rejects the row
+	Default    any    `json:"default"`    // used as is when the value is empty
+	Cycle      []any  `json:"cycle"`      // field without a column, value rotates through Cycle every CycleEvery recs (test data)
+	CycleEvery int    `json:"cycleEvery"` // default 1
+	col        int    // resolved column index, -1 if none
+}
+
+// SkipRule skips rows by the value of 1 column. Only 1 of Empty, Equals or Match is needed.
+type SkipRule struct {
+	Col    string   `json:"col"`
+	Idx    *int     `json:"idx"`
+	Empty  bool     `json:"empty"`  // value is "" (after trimming spaces)
+	Equals []string `json:"equals"` // value is one of these
+	Match  string   `json:"match"`  // value matches this regexp
+	re     *regexp.Regexp
+	col    int
+}
+
+// rejectErr is the reason a row was rejected, Kind is used to group the rejected rows summary.
+type rejectErr struct {
+	Fld  string
+	Kind string
+	Val  string
+}
+
+func (e *rejectErr) Error() string {
+	if e.Val == "" {
+		return e.Fld + ": " + e.Kind
+	}
+	return fmt.Sprintf("%s: %s %q", e.Fld, e.Kind, e.Val)
+}
+
+// Func loadMapping reads and checks the mapping file. Column names are resolved later by resolve.
+func loadMapping(path string) (*Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mapping{Delimiter: ",", BatchSize: 1000, Workers: 4}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if m.BktName == "" {
+		return nil, fmt.Errorf("bktName is required")
+	}
+	if len([]rune(m.Delimiter)) != 1 {
+		return nil, fmt.Errorf("delimiter must be 1 character")
+	}
+	if m.BatchSize < 1 || m.Workers < 1 {
+		return nil, fmt.Errorf("batchSize and workers must be > 0")
+	}
+	var keyOk bool
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		if f.Fld == "" {
+			return nil, fmt.Errorf("fields[%d] fld is required", i)
+		}
+		switch f.Type {
+		case "":
+			f.Type = "string"
+		case "string", "int", "float", "bool", "json":
+		case "date":
+			if f.Format == "" {
+				f.Format = time.DateOnly
+			}
+			if f.OutFormat == "" {
+				f.OutFormat = time.DateOnly
+			}
+		default:
+			return nil, fmt.Errorf("fields[%d] %s type %q must be string, int, float, bool, date or json", i, f.Fld, f.Type)
+		}
+		if f.Cycle != nil && len(f.Cycle) == 0 {
+			return nil, fmt.Errorf("fields[%d] %s cycle is empty", i, f.Fld)
+		}
+		if f.Cycle != nil && (f.Col != "" || f.Idx != nil) {
+			return nil, fmt.Errorf("fields[%d] %s has both a column and cycle", i, f.Fld)
+		}
+		if f.Cycle == nil && f.Col == "" && f.Idx == nil && f.Default == nil {
+			return nil, fmt.Errorf("fields[%d] %s needs col, idx, cycle or default", i, f.Fld)
+		}
+		if f.CycleEvery < 1 {
+			f.CycleEvery = 1
+		}
+		if f.Fld == m.KeyField {
+			if f.Type != "string" || !f.Required {
+				return nil, fmt.Errorf("keyField %s must be a required string field", f.Fld)
+			}
+			if f.Col == "" && f.Idx == nil { // cycle and default values are not unique, and cycle values may not be strings
+				return nil, fmt.Errorf("keyField %s must have a col or idx", f.Fld)
+			}
+			keyOk = true
+		}
+	}
+	if !keyOk {
+		return nil, fmt.Errorf("keyField %q must be one of the fields", m.KeyField)
+	}
+	for i := range m.Skip {
+		s := &m.Skip[i]
+		if s.Match != "" {
+			if s.re, err = regexp.Compile(s.Match); err != nil {
+				return nil, fmt.Errorf("skip[%d] match: %w", i, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Func resolve sets the column index of each Field and SkipRule, using the header row if Mapping.Header is set.
+func (m *Mapping) resolve(header []string) error {
+	index := func(col string, idx *int) (int, error) {
+		switch {
+		case col != "":
+			for i, name := range header {
+				if strings.TrimSpace(name) == col {
+					return i, nil
+				}
+			}
+			if !m.Header {
+				return 0, fmt.Errorf("col %q needs header true, or use idx", col)
+			}
+			return 0, fmt.Errorf("col %q is not in the header", col)
+		case idx != nil:
+			if *idx < 0 {
+				return 0, fmt.Errorf("idx %d must be >= 0", *idx)
+			}
+			return *idx, nil
+		}
+		return -1, nil
+	}
+	var err error
+	for i := range m.Fields {
+		if m.Fields[i].col, err = index(m.Fields[i].Col, m.Fields[i].Idx); err != nil {
+			return fmt.Errorf("fields[%d] %s %w", i, m.Fields[i].Fld, err)
+		}
+	}
+	for i := range m.Skip {
+		if m.Skip[i].col, err = index(m.Skip[i].Col, m.Skip[i].Idx); err != nil {
+			return fmt.Errorf("skip[%d] %w", i, err)
+		}
+		if m.Skip[i].col < 0 {
+			return fmt.Errorf("skip[%d] col or idx is required", i)
+		}
+	}
+	return nil
+}
+
+// Func skipRule returns the index of the first SkipRule matching row, or -1.
+func (m *Mapping) skipRule(row []string) int {
+	for i, s := range m.Skip {
+		val := ""
+		if s.col < len(row) {
+			val = strings.TrimSpace(row[s.col])
+		}
+		if (s.Empty && val == "") || (s.Equals != nil && slices.Contains(s.Equals, val)) || (s.re != nil && s.re.MatchString(val)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Func rec returns row as a json rec, n is the number of recs built before this one (used by Cycle).
+func (m *Mapping) rec(row []string, n int) ([]byte, error) {
+	rec := make(map[string]any, len(m.Fields)+len(m.Defaults))
+	for fld, val := range m.Defaults {
+		setFld(rec, fld, val)
+	}
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		if f.Cycle != nil {
+			setFld(rec, f.Fld, f.Cycle[(n/f.CycleEvery)%len(f.Cycle)])
+			continue
+		}
+		raw := ""
+		if f.col >= 0 {
+			if f.col >= len(row) {
+				return nil, &rejectErr{Fld: f.Fld, Kind: fmt.Sprintf("missing column %d", f.col)}
+			}
+			raw = strings.TrimSpace(row[f.col])
+		}
+		if raw == "" {
+			switch {
+			case f.Required:
+				return nil, &rejectErr{Fld: f.Fld, Kind: "required value is empty"}
+			case f.Default != nil:
+				setFld(rec, f.Fld, f.Default)
+			case f.Type == "string":
+				setFld(rec, f.Fld, "")
+			} // other types are left out of the rec, rather than stored as 0 or false
+			continue
+		}
+		val, err := f.coerce(raw)
+		if err != nil {
+			return nil, err
+		}
+		setFld(rec, f.Fld, val)
+	}
+	return json.Marshal(rec)
+}
+
+// Func coerce converts a non empty csv value to the Field Type.
+func (f *Field) coerce(raw string) (any, error) {
+	switch f.Type {
+	case "int":
+		if v, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return v, nil
+		}
+	case "float":
+		if v, err := strconv.ParseFloat(raw, 64); err == nil {
+			return v, nil
+		}
+	case "bool":
+		switch strings.ToLower(raw) {
+		case "1", "t", "true", "y", "yes":
+			return true, nil
+		case "0", "f", "false", "n", "no":
+			return false, nil
+		}
+	case "date":
+		if t, err := time.Parse(f.Format, raw); err == nil {
+			return t.Format(f.OutFormat), nil
+		}
+	case "json":
+		if json.Valid([]byte(raw)) {
+			return json.RawMessage(raw), nil
+		}
+	default:
+		return raw, nil
+	}
+	return nil, &rejectErr{Fld: f.Fld, Kind: "invalid " + f.Type, Val: raw}
+}
+
+// Func setFld sets rec[fld], creating nested objects for each "." in fld.
+func setFld(rec map[string]any, fld string, val any) {
+	for {
+		name, rest, nested := strings.Cut(fld, ".")
+		if !nested {
+			rec[name] = val
+			return
+		}
+		child, ok := rec[name].(map[string]any)
+		if ok {
+			child = maps.Clone(child) // may be a Defaults value shared by all recs
+		} else {
+			child = make(map[string]any)
+		}
+		rec[name] = child
+		rec, fld = child, rest
+	}
+}
@@ -0,0 +1,120 @@
+// Program restore.go restores a backup made by the server /admin/backup endpoint (or kvf.Backup) into a new db file.
+// Gzip compressed backups are detected and decompressed. The backup is copied to a temp file next to -db,
+// opened with bolt and integrity checked (tx.Check), then renamed to -db. An existing -db file is never replaced,
+// stop the server and move the old file away first, or restore to a new path and point the server dbPath at it.
+//
+//	go run ./restore -backup kvf-backup-20240101-120000.db.gz -db kvf.db
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func main() {
+	backupPath := flag.String("backup", "", "backup file to restore, .db or .db.gz")
+	dbPath := flag.String("db", "", "new db file to create, must not exist")
+	flag.Parse()
+	if *backupPath == "" || *dbPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := restore(*backupPath, *dbPath); err != nil {
+		log.Fatalln("restore failed:", err)
+	}
+}
+
+// Func restore copies the backup to a temp file, checks it and renames it to dbPath.
+func restore(backupPath, dbPath string) error {
+	if _, err := os.Stat(dbPath); err == nil {
+		return fmt.Errorf("db file already exists, restore only creates new files: %s", dbPath)
+	}
+	in, err := os.Open(backupPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	src, err := backupReader(in)
+	if err != nil {
+		return fmt.Errorf("read backup: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after rename
+	n, err := io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("copy backup: %w", err)
+	}
+	log.Println("copied", n, "bytes")
+
+	buckets, keys, err := check(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("backup is not a valid db: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil { // same mode the server uses for new db files
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dbPath); err != nil {
+		return err
+	}
+	log.Println("restored", dbPath, "-", buckets, "buckets,", keys, "keys")
+	return nil
+}
+
+// Func backupReader returns r, decompressed if it starts with the gzip magic number.
+func backupReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// Func check opens the db read only, runs the bolt integrity check and counts buckets and keys.
+func check(path string) (buckets, keys int, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		var checkErr error
+		for e := range tx.Check() { // first error is enough, but the channel must be drained
+			if checkErr == nil {
+				checkErr = e
+			}
+		}
+		if checkErr != nil {
+			return checkErr
+		}
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			buckets++
+			keys += b.Stats().KeyN
+			return nil
+		})
+	})
+	return buckets, keys, err
+}
@@ -0,0 +1,277 @@
+// File auth.go contains api key authentication and per bucket permissions.
+// Keys are listed in the config file (see apiKeys in config.example.json), each with permissions by bucket name.
+// Bucket name "*" applies to all buckets not listed. Permission levels, each includes the ones before it:
+//   read  - get, getone, getall, qry, qryexport, export, changes, watch
+//   write - put, putone, delete, import (import also needs admin to create or replace a bkt)
+//   admin - bkt create/delete, /admin/shutdown and /admin/backup (admin on "*" only, see authorizeAdmin)
+// Client sends the key in the Authorization header as "Bearer <key>", kvf.Run does this if kvf.APIKey is set.
+// If no apiKeys are configured, authentication is disabled and all requests are allowed.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"kvfun/kvf"
+)
+
+// Permission levels
+const (
+	permNone int = iota
+	permRead
+	permWrite
+	permAdmin
+)
+
+var permLevels = map[string]int{
+	"read":  permRead,
+	"write": permWrite,
+	"admin": permAdmin,
+}
+
+// opPerms is the permission required for each request op, on the request bucket
+var opPerms = map[string]int{
+	"get":       permRead,
+	"getone":    permRead,
+	"getall":    permRead,
+	"qry":       permRead,
+	"qryexport": permRead,
+	"export":    permRead,
+	"changes":   permRead,
+	"watch":     permRead,
+	"put":       permWrite,
+	"putone":    permWrite,
+	"delete":    permWrite,
+	"import":    permWrite,
+	"bkt":       permAdmin,
+}
+
+// APIKeyConfig is an apiKeys entry in the config file.
+// Either Key or KeyHash (hex sha256 of the key) is set, KeyHash keeps the key itself out of the config file.
+type APIKeyConfig struct {
+	Name    string            `json:"name"`    // used in log messages, never log the key
+	Key     string            `json:"key"`     // min 16 chars
+	KeyHash string            `json:"keyHash"` // hex sha256 of key
+	Perms   map[string]string `json:"perms"`   // bucket name (or "*") -> read, write or admin
+}
+
+// apiKey is a loaded APIKeyConfig
+type apiKey struct {
+	name  string
+	perms map[string]int
+}
+
+// keys by sha256 of key, lookup by hash avoids comparing key strings directly
+var apiKeys map[[sha256.Size]byte]*apiKey
+
+// Func loadAPIKeys builds apiKeys from validated config.
+func loadAPIKeys(keyCfgs []APIKeyConfig) {
+	if len(keyCfgs) == 0 {
+		slog.Warn("no apiKeys configured, all requests are allowed")
+		return
+	}
+	apiKeys = make(map[[sha256.Size]byte]*apiKey, len(keyCfgs))
+	for _, kc := range keyCfgs {
+		key := &apiKey{name: kc.Name, perms: make(map[string]int, len(kc.Perms))}
+		for bktName, perm := range kc.Perms {
+			key.perms[bktName] = permLevels[perm]
+		}
+		apiKeys[keyHash(kc)] = key
+	}
+	slog.Info("api keys loaded", "count", len(apiKeys))
+}
+
+func keyHash(kc APIKeyConfig) [sha256.Size]byte {
+	if kc.Key != "" {
+		return sha256.Sum256([]byte(kc.Key))
+	}
+	var hash [sha256.Size]byte
+	hex.Decode(hash[:], []byte(kc.KeyHash)) // format checked by validateAPIKeys
+	return hash
+}
+
+// Func validateAPIKeys checks apiKeys config entries, used by Config.validate.
+func validateAPIKeys(keyCfgs []APIKeyConfig) []error {
+	var errs []error
+	seen := make(map[[sha256.Size]byte]bool)
+	for i, kc := range keyCfgs {
+		prefix := fmt.Sprintf("apiKeys[%d] %q", i, kc.Name)
+		switch {
+		case kc.Name == "":
+			errs = append(errs, fmt.Errorf("%s: name is required", prefix))
+		case kc.Key != "" && kc.KeyHash != "":
+			errs = append(errs, fmt.Errorf("%s: set key or keyHash, not both", prefix))
+		case kc.Key == "" && kc.KeyHash == "":
+			errs = append(errs, fmt.Errorf("%s: key or keyHash is required", prefix))
+		case kc.Key != "" && len(kc.Key) < 16:
+			errs = append(errs, fmt.Errorf("%s: key must be at least 16 characters", prefix))
+		case kc.KeyHash != "" && !validKeyHash(kc.KeyHash):
+			errs = append(errs, fmt.Errorf("%s: keyHash must be a hex sha256 (64 chars)", prefix))
+		default:
+			hash := keyHash(kc)
+			if seen[hash] {
+				errs = append(errs, fmt.Errorf("%s: duplicate key", prefix))
+			}
+			seen[hash] = true
+		}
+		if len(kc.Perms) == 0 {
+			errs = append(errs, fmt.Errorf("%s: perms is required", prefix))
+		}
+		for bktName, perm := range kc.Perms {
+			if _, found := permLevels[perm]; !found {
+				errs = append(errs, fmt.Errorf("%s: perms %q: %q must be read, write or admin", prefix, bktName, perm))
+			}
+		}
+	}
+	return errs
+}
+
+func validKeyHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+var errNoAPIKey = errors.New("missing api key")
+
+// Func requestKey returns the apiKey sent in the request Authorization header.
+func requestKey(r *http.Request) (*apiKey, error) {
+	auth := r.Header.Get("Authorization")
+	token, found := strings.CutPrefix(auth, "Bearer ")
+	if !found || token == "" {
+		return nil, errNoAPIKey
+	}
+	key, found := apiKeys[sha256.Sum256([]byte(token))]
+	if !found {
+		return nil, errors.New("invalid api key")
+	}
+	return key, nil
+}
+
+// Func allows returns true if key has at least perm on bktName.
+func (key *apiKey) allows(bktName string, perm int) bool {
+	level, found := key.perms[bktName]
+	if !found {
+		level = key.perms["*"]
+	}
+	return level >= perm
+}
+
+// Func allowsAll returns true if key has at least perm on every bkt, including those listed in its perms.
+func (key *apiKey) allowsAll(perm int) bool {
+	for _, level := range key.perms {
+		if level < perm {
+			return false
+		}
+	}
+	return key.perms["*"] >= perm
+}
+
+// Func authenticate checks the request sends a valid api key, before its body is read, so clients without
+// a key cannot make the server read and decode large bodies. Bkt permissions are checked after decode by authorize.
+// If the key is missing or invalid, an unauthorized Response is sent and returned.
+func authenticate(w http.ResponseWriter, r *http.Request) *kvf.Response {
+	if apiKeys == nil { // authentication disabled
+		return nil
+	}
+	if _, err := requestKey(r); err != nil {
+		reqLogger(r).Info("request rejected", "err", err, "remote_addr", r.RemoteAddr)
+		return writeError(w, r, kvf.CodeUnauthorized, "Unauthorized - "+err.Error())
+	}
+	return nil
+}
+
+// Func authorize checks the request api key has the permission op requires on the request bucket.
+// If not, an unauthorized or forbidden Response is sent and returned. Nil is returned if the request is allowed.
+func authorize(w http.ResponseWriter, r *http.Request, op string, request any) *kvf.Response {
+	if apiKeys == nil { // authentication disabled
+		return nil
+	}
+	key, err := requestKey(r)
+	if err != nil { // also checked by authenticate, unless the request has no body (ex. GET /watch)
+		reqLogger(r).Info("request rejected", "err", err, "remote_addr", r.RemoteAddr)
+		return writeError(w, r, kvf.CodeUnauthorized, "Unauthorized - "+err.Error())
+	}
+	perm, found := opPerms[op]
+	if !found { // new ops must be added to opPerms, until then only admin keys are allowed
+		perm = permAdmin
+	}
+	bktNames, all := requestBkts(request)
+	if all && !key.allowsAll(perm) {
+		reqLogger(r).Info("request rejected, permission denied", "key", key.name, "bkt", "all")
+		return writeError(w, r, kvf.CodeForbidden, "Permission Denied - "+op+" on all bkts")
+	}
+	for _, bktName := range bktNames {
+		if !key.allows(bktName, perm) {
+			reqLogger(r).Info("request rejected, permission denied", "key", key.name, "bkt", bktName)
+			return writeError(w, r, kvf.CodeForbidden, "Permission Denied - "+op+" on bkt "+bktName)
+		}
+	}
+	reqLogger(r).Debug("request authorized", "key", key.name, "bkts", bktNames)
+	return nil
+}
+
+// Func authorizeAdmin allows an admin endpoint (action is ex. "Shutdown", used in messages) if the request api key has admin permission on "*",
+// or request header kvf.AdminTokenHeader matches cfg.AdminToken. If no AdminToken is configured, only api keys are accepted.
+// If not allowed, an unauthorized or forbidden Response is sent and false is returned.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, action string) bool {
+	if key, err := requestKey(r); err == nil && key.allows("*", permAdmin) {
+		slog.Info("admin request authorized by api key", "action", action, "key", key.name)
+		return true
+	}
+	if cfg.AdminToken == "" {
+		slog.Info("admin request rejected, no adminToken configured", "action", action, "remote_addr", r.RemoteAddr)
+		writeError(w, r, kvf.CodeForbidden, "Admin "+action+" Disabled")
+		return false
+	}
+	if token := r.Header.Get(kvf.AdminTokenHeader); subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+		slog.Info("admin request rejected, invalid token", "action", action, "remote_addr", r.RemoteAddr)
+		writeError(w, r, kvf.CodeUnauthorized, "Invalid Admin Token")
+		return false
+	}
+	return true
+}
+
+// Func requestBkts returns the bkt names of a request, all is true if the request applies to all bkts.
+func requestBkts(request any) (bktNames []string, all bool) {
+	switch req := request.(type) {
+	case *kvf.ExportRequest:
+		return req.BktNames, len(req.BktNames) == 0
+	case *kvf.ChangesRequest: // entries of all bkts unless filtered by BktName
+		return []string{req.BktName}, req.BktName == ""
+	case *kvf.WatchRequest:
+		return []string{req.BktName}, req.BktName == ""
+	}
+	return []string{requestBkt(request)}, false
+}
+
+// Func requestBkt returns the BktName of a single bkt request.
+func requestBkt(request any) string {
+	switch req := request.(type) {
+	case *kvf.GetRequest:
+		return req.BktName
+	case *kvf.GetOneRequest:
+		return req.BktName
+	case *kvf.GetAllRequest:
+		return req.BktName
+	case *kvf.PutRequest:
+		return req.BktName
+	case *kvf.PutOneRequest:
+		return req.BktName
+	case *kvf.DeleteRequest:
+		return req.BktName
+	case *kvf.QryRequest:
+		return req.BktName
+	case *kvf.QryExportRequest:
+		return req.Qry.BktName
+	case *kvf.BktRequest:
+		return req.BktName
+	}
+	return ""
+}
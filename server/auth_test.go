@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kvfun/kvf"
+)
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	r    io.Reader
+	read int
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *countingBody) Close() error { return nil }
+
+// A missing or unknown api key is rejected before the body is read, bkt permissions are checked after decode.
+func TestDecodeRequestAuth(t *testing.T) {
+	defer func(c *Config) { cfg = c }(cfg)
+	cfg = &Config{}
+	defer func() { apiKeys = nil }()
+	loadAPIKeys([]APIKeyConfig{{Name: "reader", Key: "test-reader-key-0001", Perms: map[string]string{"location": "read"}}})
+
+	tests := []struct {
+		name     string
+		auth     string
+		bktName  string
+		wantCode string
+		wantRead bool
+	}{
+		{"missing key", "", "location", kvf.CodeUnauthorized, false},
+		{"unknown key", "Bearer not-a-valid-key-0000", "location", kvf.CodeUnauthorized, false},
+		{"no bkt permission", "Bearer test-reader-key-0001", "other", kvf.CodeForbidden, true},
+		{"allowed", "Bearer test-reader-key-0001", "location", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &countingBody{r: strings.NewReader(`{"bktName":"` + tt.bktName + `"}`)}
+			r := httptest.NewRequest(http.MethodPost, "/qry", body)
+			r.Header.Set("Content-Type", "application/json")
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			var request kvf.QryRequest
+			resp := decodeRequest("qry", &request, w, r)
+			code := ""
+			if resp != nil {
+				code = resp.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+			if (body.read > 0) != tt.wantRead {
+				t.Errorf("body read %d bytes, want read %v", body.read, tt.wantRead)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// File backup.go contains the /admin/backup endpoint, which copies the live db without stopping the server.
+// The copy is written by bolt tx.WriteTo inside a read tx, so it is a consistent snapshot as of the
+// last committed write. Writers are not blocked while the copy runs.
+//   GET or POST /admin/backup            - streams the db file (Content-Type application/octet-stream)
+//   GET or POST /admin/backup?gzip=true  - streams the db file gzip compressed (.db.gz)
+//   POST /admin/backup?path=name[&gzip=true] - writes the copy to cfg.BackupDir/name on the server
+// Authorized by authorizeAdmin (see auth.go). Use "go run ./restore" to restore a backup into a new db file.
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"kvfun/kvf"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Func backupHandler streams a snapshot of the db, or writes it to cfg.BackupDir.
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, r, kvf.CodeMethodNotAllowed, "Method Not Allowed - use GET or POST")
+		return
+	}
+	if !authorizeAdmin(w, r, "Backup") { // see auth.go
+		return
+	}
+	compress := r.URL.Query().Get("gzip") == "true"
+	if name := r.URL.Query().Get("path"); name != "" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, r, kvf.CodeMethodNotAllowed, "Method Not Allowed - use POST to write a server side backup")
+			return
+		}
+		backupToFile(w, r, name, compress)
+		return
+	}
+
+	start := time.Now()
+	filename := "kvf-backup-" + start.Format("20060102-150405") + ".db"
+	if compress {
+		filename += ".gz"
+	}
+	var size int64
+	err := db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if !compress {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		return writeSnapshot(tx, w, compress)
+	})
+	if err != nil {
+		// headers are already sent, so abort the connection rather than end the body normally.
+		// The client sees an incomplete transfer instead of a short, apparently valid file.
+		slog.Error("backup stream failed", "err", err, "remote_addr", r.RemoteAddr)
+		panic(http.ErrAbortHandler)
+	}
+	slog.Info("backup streamed", "bytes", size, "gzip", compress, "duration", time.Since(start))
+}
+
+// Func backupToFile writes the snapshot to name in cfg.BackupDir and sends an Ok Response with the path.
+// The file is written to a temp file and renamed, so a partial backup is never left under name.
+func backupToFile(w http.ResponseWriter, r *http.Request, name string, compress bool) {
+	if cfg.BackupDir == "" {
+		writeError(w, r, kvf.CodeForbidden, "Server Side Backup Disabled - backupDir is not configured")
+		return
+	}
+	path, err := backupPath(name)
+	if err != nil {
+		writeError(w, r, kvf.CodeValidation, "Invalid Backup Path - "+err.Error())
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		writeError(w, r, kvf.CodeConflict, "Backup File Exists - "+name)
+		return
+	}
+
+	start := time.Now()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		slog.Error("backup temp file create failed", "err", err)
+		writeError(w, r, kvf.CodeInternal, "Backup Failed - "+err.Error())
+		return
+	}
+	defer os.Remove(tmp.Name()) // no-op after rename
+	err = db.View(func(tx *bolt.Tx) error {
+		return writeSnapshot(tx, tmp, compress)
+	})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		slog.Error("backup to file failed", "path", path, "err", err)
+		writeError(w, r, kvf.CodeInternal, "Backup Failed - "+err.Error())
+		return
+	}
+	info, _ := os.Stat(path)
+	slog.Info("backup written", "path", path, "bytes", info.Size(), "gzip", compress, "duration", time.Since(start))
+	writeResponse(w, r, &kvf.Response{Status: kvf.Ok, Msg: fmt.Sprintf("backup written - %s (%d bytes)", path, info.Size())})
+}
+
+// Func backupPath returns name joined to cfg.BackupDir. Name must be a plain file name or a relative
+// path that stays inside BackupDir, ex. "daily/kvf.db". Missing sub directories are created.
+func backupPath(name string) (string, error) {
+	clean := filepath.Clean(name)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) || clean == "." {
+		return "", fmt.Errorf("%q must be a relative path inside backupDir", name)
+	}
+	path := filepath.Join(cfg.BackupDir, clean)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Func writeSnapshot writes the db as of tx to w, gzip compressed if compress is set.
+func writeSnapshot(tx *bolt.Tx, w io.Writer, compress bool) error {
+	if !compress {
+		_, err := tx.WriteTo(w)
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	if _, err := tx.WriteTo(zw); err != nil {
+		return err
+	}
+	return zw.Close()
+}
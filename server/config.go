@@ -0,0 +1,552 @@
+// File config.go loads the server configuration.
+// Values are applied in this order, later sources override earlier ones:
+//   defaults (see defaultConfig) -> json config file -> environment variables -> command line flags
+// The config file is given by flag -config or env var KVF_CONFIG, see config.example.json.
+// Run "server -h" to list flags and their environment variables.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"kvfun/kvf"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type Config struct {
+	DBPath          string          `json:"dbPath"`
+	Addr            string          `json:"addr"`        // listen address, host:port
+	LogLevel        string          `json:"logLevel"`    // debug, info, warn, error
+	LogFormat       string          `json:"logFormat"`   // text or json
+	SlowRequest     Duration        `json:"slowRequest"` // requests taking longer are logged with the full request, 0 disables
+	CompressMinSize int             `json:"compressMinSize"`
+	AdminToken      string          `json:"adminToken"`      // required by /admin/shutdown, "" disables it
+	ShutdownTimeout Duration        `json:"shutdownTimeout"` // max wait for in-flight requests at shutdown
+	BackupDir       string          `json:"backupDir"`       // server side /admin/backup files are written here, "" disables
+	Bolt            BoltConfig      `json:"bolt"`
+	Limits          LimitsConfig    `json:"limits"`
+	Timeouts        TimeoutsConfig  `json:"timeouts"`
+	ChangeLog       ChangeLogConfig `json:"changeLog"`
+	APIKeys         []APIKeyConfig  `json:"apiKeys"` // config file only, see auth.go
+	TLS             TLSConfig       `json:"tls"`
+}
+
+// TLSConfig enables https if CertFile and KeyFile are set, see tlsConfig.
+// Use "go run ./certgen" to generate self-signed test certificates.
+type TLSConfig struct {
+	CertFile     string `json:"certFile"`     // server certificate (pem), may include intermediates
+	KeyFile      string `json:"keyFile"`      // server private key (pem)
+	ClientCAFile string `json:"clientCAFile"` // CA certificates (pem) used to verify client certificates
+	ClientAuth   string `json:"clientAuth"`   // "none" (default), "verify-if-given" or "require" - requires clientCAFile
+	MinVersion   string `json:"minVersion"`   // "1.2" (default) or "1.3"
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                tls.NoClientCert,
+	"none":            tls.NoClientCert,
+	"verify-if-given": tls.VerifyClientCertIfGiven,
+	"require":         tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// BoltConfig values are passed to bolt.Open as bolt.Options.
+type BoltConfig struct {
+	Timeout         Duration `json:"timeout"`         // time to wait for db file lock, 0 waits forever
+	NoSync          bool     `json:"noSync"`          // skip fsync after commit, unsafe - only for bulk loads/testing
+	InitialMmapSize int      `json:"initialMmapSize"` // bytes, avoids remapping (which blocks writers) as db grows
+	FreelistType    string   `json:"freelistType"`    // "array" or "map"
+	ReadOnly        bool     `json:"readOnly"`        // open db read-only (shared lock), writes fail, see /readyz
+	BatchWrites     bool     `json:"batchWrites"`     // put, putone and delete use db.Batch, see writeTx in server.go
+	MaxBatchSize    int      `json:"maxBatchSize"`    // max requests per batch commit
+	MaxBatchDelay   Duration `json:"maxBatchDelay"`   // max wait for other requests to join a batch
+}
+
+// ChangeLogConfig sets the kvf change log vars, see kvf/changelog.go.
+type ChangeLogConfig struct {
+	Enabled    bool   `json:"enabled"`    // puts, deletes and bkt ops append entries to the _changes bkt
+	Values     string `json:"values"`     // rec values stored in entries: none, after or both
+	MaxEntries uint64 `json:"maxEntries"` // oldest entries are deleted beyond this, 0 keeps all
+}
+
+type LimitsConfig struct {
+	MaxBodyBytes   int64 `json:"maxBodyBytes"`   // max request body size, 0 is no limit
+	MaxQryParallel int   `json:"maxQryParallel"` // upper limit for QryRequest.Parallel
+	MaxPutRecs     int   `json:"maxPutRecs"`     // recs per put, 0 is no limit
+	MaxKeys        int   `json:"maxKeys"`        // keys per get and delete, 0 is no limit
+	MaxConditions  int   `json:"maxConditions"`  // findConditions per qry, 0 is no limit
+	MaxSortFlds    int   `json:"maxSortFlds"`    // sortFlds per qry, 0 is no limit
+	MaxRecBytes    int   `json:"maxRecBytes"`    // size of each rec in put and putone, 0 is no limit
+}
+
+// Func kvfLimits returns the request limits checked by kvf.Validate.
+func (l *LimitsConfig) kvfLimits() kvf.Limits {
+	return kvf.Limits{
+		MaxPutRecs:    l.MaxPutRecs,
+		MaxKeys:       l.MaxKeys,
+		MaxConditions: l.MaxConditions,
+		MaxSortFlds:   l.MaxSortFlds,
+		MaxRecBytes:   l.MaxRecBytes,
+	}
+}
+
+// TimeoutsConfig sets server side time limits by op (get, qry, put, ...), 0 is no limit.
+// When reached, read ops stop scanning and return Code "timeout", write ops only check before the first write.
+type TimeoutsConfig struct {
+	Default Duration            `json:"default"` // for ops not listed in Ops
+	Ops     map[string]Duration `json:"ops"`     // ex. {"qry": "30s", "getall": "10s"}
+}
+
+// Func opTimeout returns the time limit for op, 0 is no limit.
+// Default does not apply to export, qryexport, import and watch, their time depends on db size (a watch stays open
+// until the client goes away), list them in Ops to limit them. A download cut off after the 1st row would only
+// report the failure in trailers, which browsers and csv tools ignore, leaving a silently truncated file.
+func (t *TimeoutsConfig) opTimeout(op string) time.Duration {
+	if d, found := t.Ops[op]; found {
+		return time.Duration(d)
+	}
+	if op == "export" || op == "qryexport" || op == "import" || op == "watch" {
+		return 0
+	}
+	return time.Duration(t.Default)
+}
+
+// Duration is a time.Duration loaded from a json string such as "1s" or "500ms".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("duration must be a string such as \"1s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = Duration(parsed)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		DBPath:          "kvf.db", // relative to the working dir, set dbPath, -db or KVF_DB_PATH for a fixed location
+		Addr:            ":8000",
+		LogLevel:        "info",
+		LogFormat:       "text",
+		SlowRequest:     Duration(time.Second),
+		CompressMinSize: 1024,
+		ShutdownTimeout: Duration(30 * time.Second),
+		Bolt: BoltConfig{
+			Timeout:       Duration(time.Second),
+			FreelistType:  string(bolt.FreelistArrayType),
+			MaxBatchSize:  bolt.DefaultMaxBatchSize,
+			MaxBatchDelay: Duration(bolt.DefaultMaxBatchDelay),
+		},
+		Limits: LimitsConfig{
+			MaxBodyBytes:   64 << 20,
+			MaxQryParallel: runtime.NumCPU(),
+			MaxPutRecs:     100000,
+			MaxKeys:        10000,
+			MaxConditions:  32,
+			MaxSortFlds:    8,
+			MaxRecBytes:    1 << 20,
+		},
+		Timeouts: TimeoutsConfig{
+			Default: Duration(time.Minute),
+		},
+		ChangeLog: ChangeLogConfig{
+			Values: kvf.ChangeValuesNone,
+		},
+	}
+}
+
+// setting is a config value that can be set by env var and flag.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, val string) error
+}
+
+var settings = []setting{
+	{"db", "KVF_DB_PATH", "bolt db file path", func(c *Config, val string) error {
+		c.DBPath = val
+		return nil
+	}},
+	{"addr", "KVF_ADDR", "listen address, host:port", func(c *Config, val string) error {
+		c.Addr = val
+		return nil
+	}},
+	{"log-level", "KVF_LOG_LEVEL", "debug, info, warn or error", func(c *Config, val string) error {
+		c.LogLevel = val
+		return nil
+	}},
+	{"log-format", "KVF_LOG_FORMAT", "text or json", func(c *Config, val string) error {
+		c.LogFormat = val
+		return nil
+	}},
+	{"slow-request", "KVF_SLOW_REQUEST", "log requests taking longer than this with the full request, ex. 1s, 0 disables", func(c *Config, val string) error {
+		d, err := time.ParseDuration(val)
+		c.SlowRequest = Duration(d)
+		return err
+	}},
+	{"compress-min-size", "KVF_COMPRESS_MIN_SIZE", "responses smaller than this (bytes) are not compressed", func(c *Config, val string) error {
+		return setInt(&c.CompressMinSize, val)
+	}},
+	{"admin-token", "KVF_ADMIN_TOKEN", "token required by /admin/shutdown, empty disables it", func(c *Config, val string) error {
+		c.AdminToken = val
+		return nil
+	}},
+	{"shutdown-timeout", "KVF_SHUTDOWN_TIMEOUT", "max wait for in-flight requests at shutdown, ex. 30s", func(c *Config, val string) error {
+		d, err := time.ParseDuration(val)
+		c.ShutdownTimeout = Duration(d)
+		return err
+	}},
+	{"tls-cert", "KVF_TLS_CERT", "server certificate file (pem), enables https", func(c *Config, val string) error {
+		c.TLS.CertFile = val
+		return nil
+	}},
+	{"tls-key", "KVF_TLS_KEY", "server private key file (pem)", func(c *Config, val string) error {
+		c.TLS.KeyFile = val
+		return nil
+	}},
+	{"tls-client-ca", "KVF_TLS_CLIENT_CA", "CA file (pem) used to verify client certificates", func(c *Config, val string) error {
+		c.TLS.ClientCAFile = val
+		return nil
+	}},
+	{"tls-client-auth", "KVF_TLS_CLIENT_AUTH", "client certificates: none, verify-if-given or require", func(c *Config, val string) error {
+		c.TLS.ClientAuth = val
+		return nil
+	}},
+	{"backup-dir", "KVF_BACKUP_DIR", "directory for server side backups, see /admin/backup", func(c *Config, val string) error {
+		c.BackupDir = val
+		return nil
+	}},
+	{"bolt-timeout", "KVF_BOLT_TIMEOUT", "time to wait for db file lock, ex. 1s", func(c *Config, val string) error {
+		d, err := time.ParseDuration(val)
+		c.Bolt.Timeout = Duration(d)
+		return err
+	}},
+	{"bolt-nosync", "KVF_BOLT_NOSYNC", "skip fsync after commit (unsafe)", func(c *Config, val string) error {
+		b, err := strconv.ParseBool(val)
+		c.Bolt.NoSync = b
+		return err
+	}},
+	{"bolt-mmap-size", "KVF_BOLT_MMAP_SIZE", "initial mmap size in bytes", func(c *Config, val string) error {
+		return setInt(&c.Bolt.InitialMmapSize, val)
+	}},
+	{"bolt-freelist", "KVF_BOLT_FREELIST", "freelist type, array or map", func(c *Config, val string) error {
+		c.Bolt.FreelistType = val
+		return nil
+	}},
+	{"bolt-readonly", "KVF_BOLT_READONLY", "open db read-only", func(c *Config, val string) error {
+		b, err := strconv.ParseBool(val)
+		c.Bolt.ReadOnly = b
+		return err
+	}},
+	{"bolt-batch", "KVF_BOLT_BATCH", "use db.Batch for put, putone and delete", func(c *Config, val string) error {
+		b, err := strconv.ParseBool(val)
+		c.Bolt.BatchWrites = b
+		return err
+	}},
+	{"bolt-batch-size", "KVF_BOLT_BATCH_SIZE", "max requests per batch commit", func(c *Config, val string) error {
+		return setInt(&c.Bolt.MaxBatchSize, val)
+	}},
+	{"bolt-batch-delay", "KVF_BOLT_BATCH_DELAY", "max wait for requests to join a batch, ex. 10ms", func(c *Config, val string) error {
+		d, err := time.ParseDuration(val)
+		c.Bolt.MaxBatchDelay = Duration(d)
+		return err
+	}},
+	{"change-log", "KVF_CHANGE_LOG", "log puts, deletes and bkt ops to the _changes bkt, see /changes", func(c *Config, val string) error {
+		b, err := strconv.ParseBool(val)
+		c.ChangeLog.Enabled = b
+		return err
+	}},
+	{"change-log-values", "KVF_CHANGE_LOG_VALUES", "rec values stored in change log entries: none, after or both", func(c *Config, val string) error {
+		c.ChangeLog.Values = val
+		return nil
+	}},
+	{"change-log-max", "KVF_CHANGE_LOG_MAX", "max change log entries kept, 0 keeps all", func(c *Config, val string) error {
+		n, err := strconv.ParseUint(val, 10, 64)
+		c.ChangeLog.MaxEntries = n
+		return err
+	}},
+	{"max-body-bytes", "KVF_MAX_BODY_BYTES", "max request body size in bytes, 0 is no limit", func(c *Config, val string) error {
+		n, err := strconv.ParseInt(val, 10, 64)
+		c.Limits.MaxBodyBytes = n
+		return err
+	}},
+	{"max-qry-parallel", "KVF_MAX_QRY_PARALLEL", "upper limit for QryRequest.Parallel", func(c *Config, val string) error {
+		return setInt(&c.Limits.MaxQryParallel, val)
+	}},
+	{"max-put-recs", "KVF_MAX_PUT_RECS", "recs per put, 0 is no limit", func(c *Config, val string) error {
+		return setInt(&c.Limits.MaxPutRecs, val)
+	}},
+	{"max-keys", "KVF_MAX_KEYS", "keys per get and delete, 0 is no limit", func(c *Config, val string) error {
+		return setInt(&c.Limits.MaxKeys, val)
+	}},
+	{"max-conditions", "KVF_MAX_CONDITIONS", "findConditions per qry, 0 is no limit", func(c *Config, val string) error {
+		return setInt(&c.Limits.MaxConditions, val)
+	}},
+	{"max-sort-flds", "KVF_MAX_SORT_FLDS", "sortFlds per qry, 0 is no limit", func(c *Config, val string) error {
+		return setInt(&c.Limits.MaxSortFlds, val)
+	}},
+	{"max-rec-bytes", "KVF_MAX_REC_BYTES", "size of each rec in put and putone, 0 is no limit", func(c *Config, val string) error {
+		return setInt(&c.Limits.MaxRecBytes, val)
+	}},
+	{"timeout", "KVF_TIMEOUT", "default op time limit, ex. 60s, 0 is no limit", func(c *Config, val string) error {
+		d, err := time.ParseDuration(val)
+		c.Timeouts.Default = Duration(d)
+		return err
+	}},
+	{"op-timeouts", "KVF_OP_TIMEOUTS", "op time limits, ex. qry=30s,getall=10s", func(c *Config, val string) error {
+		c.Timeouts.Ops = make(map[string]Duration)
+		for _, pair := range strings.Split(val, ",") {
+			op, limit, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if !found {
+				return fmt.Errorf("%q must be op=duration", pair)
+			}
+			d, err := time.ParseDuration(limit)
+			if err != nil {
+				return err
+			}
+			c.Timeouts.Ops[op] = Duration(d)
+		}
+		return nil
+	}},
+}
+
+func setInt(dst *int, val string) error {
+	n, err := strconv.Atoi(val)
+	*dst = n
+	return err
+}
+
+// Func loadConfig builds Config from defaults, config file, env vars and args (command line flags).
+func loadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("KVF_CONFIG"), "json config file path (env KVF_CONFIG)")
+	flagVals := make(map[string]string) // applied after env vars, so flags win
+	for _, s := range settings {
+		name := s.flag
+		fs.Func(name, s.usage+" (env "+s.env+")", func(val string) error {
+			flagVals[name] = val
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		if err := loadConfigFile(cfg, *configPath); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range settings {
+		if val, found := os.LookupEnv(s.env); found {
+			if err := s.set(cfg, val); err != nil {
+				return nil, fmt.Errorf("env %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if val, found := flagVals[s.flag]; found {
+			if err := s.set(cfg, val); err != nil {
+				return nil, fmt.Errorf("flag -%s: %w", s.flag, err)
+			}
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadConfigFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open config file: %w", err)
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields() // catch misspelled settings
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Func validate checks all config values, all problems are returned together.
+func (c *Config) validate() error {
+	var errs []error
+	if c.DBPath == "" {
+		errs = append(errs, errors.New("dbPath is required"))
+	} else if info, err := os.Stat(filepath.Dir(c.DBPath)); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("dbPath directory does not exist: %s", filepath.Dir(c.DBPath)))
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr %q: %w", c.Addr, err))
+	}
+	if _, found := logLevels[strings.ToLower(c.LogLevel)]; !found {
+		errs = append(errs, fmt.Errorf("logLevel %q must be debug, info, warn or error", c.LogLevel))
+	}
+	if !logFormats[c.LogFormat] {
+		errs = append(errs, fmt.Errorf("logFormat %q must be text or json", c.LogFormat))
+	}
+	if c.SlowRequest < 0 {
+		errs = append(errs, errors.New("slowRequest must be >= 0"))
+	}
+	if c.CompressMinSize < 0 {
+		errs = append(errs, errors.New("compressMinSize must be >= 0"))
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		errs = append(errs, errors.New("adminToken must be at least 16 characters"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdownTimeout must be > 0"))
+	}
+	if c.BackupDir != "" {
+		if info, err := os.Stat(c.BackupDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("backupDir does not exist: %s", c.BackupDir))
+		}
+	}
+	if c.Bolt.Timeout < 0 {
+		errs = append(errs, errors.New("bolt.timeout must be >= 0"))
+	}
+	if c.Bolt.InitialMmapSize < 0 {
+		errs = append(errs, errors.New("bolt.initialMmapSize must be >= 0"))
+	}
+	if c.Bolt.BatchWrites && (c.Bolt.MaxBatchSize < 1 || c.Bolt.MaxBatchDelay <= 0) {
+		errs = append(errs, errors.New("bolt.maxBatchSize must be >= 1 and maxBatchDelay > 0 when batchWrites is set"))
+	}
+	switch bolt.FreelistType(c.Bolt.FreelistType) {
+	case bolt.FreelistArrayType, bolt.FreelistMapType:
+	default:
+		errs = append(errs, fmt.Errorf("bolt.freelistType %q must be array or map", c.Bolt.FreelistType))
+	}
+	if c.Limits.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("limits.maxBodyBytes must be >= 0"))
+	}
+	if c.Limits.MaxQryParallel < 1 {
+		errs = append(errs, errors.New("limits.maxQryParallel must be >= 1"))
+	}
+	if c.Limits.MaxPutRecs < 0 || c.Limits.MaxKeys < 0 || c.Limits.MaxConditions < 0 || c.Limits.MaxSortFlds < 0 || c.Limits.MaxRecBytes < 0 {
+		errs = append(errs, errors.New("limits.maxPutRecs, maxKeys, maxConditions, maxSortFlds and maxRecBytes must be >= 0"))
+	}
+	if c.Limits.MaxBodyBytes > 0 && int64(c.Limits.MaxRecBytes) > c.Limits.MaxBodyBytes {
+		errs = append(errs, errors.New("limits.maxRecBytes must be <= maxBodyBytes"))
+	}
+	if c.Timeouts.Default < 0 {
+		errs = append(errs, errors.New("timeouts.default must be >= 0"))
+	}
+	for op, d := range c.Timeouts.Ops {
+		if _, found := opPerms[op]; !found {
+			errs = append(errs, fmt.Errorf("timeouts.ops %q is not an op", op))
+		}
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("timeouts.ops %q must be >= 0", op))
+		}
+	}
+	switch c.ChangeLog.Values {
+	case kvf.ChangeValuesNone, kvf.ChangeValuesAfter, kvf.ChangeValuesBoth:
+	default:
+		errs = append(errs, fmt.Errorf("changeLog.values %q must be none, after or both", c.ChangeLog.Values))
+	}
+	errs = append(errs, validateAPIKeys(c.APIKeys)...)
+	errs = append(errs, c.TLS.validate()...)
+	return errors.Join(errs...)
+}
+
+// Func boltOptions returns options passed to bolt.Open.
+func (c *Config) boltOptions() *bolt.Options {
+	return &bolt.Options{
+		Timeout:         time.Duration(c.Bolt.Timeout),
+		NoSync:          c.Bolt.NoSync,
+		InitialMmapSize: c.Bolt.InitialMmapSize,
+		FreelistType:    bolt.FreelistType(c.Bolt.FreelistType),
+		ReadOnly:        c.Bolt.ReadOnly,
+	}
+}
+
+// Func validate checks TLSConfig values, files are loaded to catch bad pem data at startup.
+func (t *TLSConfig) validate() []error {
+	var errs []error
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, errors.New("tls.certFile and tls.keyFile must both be set"))
+	} else if t.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("tls cert/key: %w", err))
+		}
+	}
+	clientAuth, found := clientAuthTypes[t.ClientAuth]
+	if !found {
+		errs = append(errs, fmt.Errorf("tls.clientAuth %q must be none, verify-if-given or require", t.ClientAuth))
+	}
+	if t.ClientCAFile != "" || clientAuth != tls.NoClientCert {
+		if t.CertFile == "" {
+			errs = append(errs, errors.New("tls.clientCAFile and tls.clientAuth require tls.certFile"))
+		}
+		if t.ClientCAFile == "" {
+			errs = append(errs, errors.New("tls.clientAuth requires tls.clientCAFile"))
+		} else if _, err := loadCertPool(t.ClientCAFile); err != nil {
+			errs = append(errs, fmt.Errorf("tls.clientCAFile: %w", err))
+		}
+	}
+	if _, found := tlsVersions[t.MinVersion]; !found {
+		errs = append(errs, fmt.Errorf("tls.minVersion %q must be 1.2 or 1.3", t.MinVersion))
+	}
+	return errs
+}
+
+// Func enabled returns true if the server should use https.
+func (t *TLSConfig) enabled() bool {
+	return t.CertFile != ""
+}
+
+// Func tlsConfig returns the tls.Config used by the http server, cert and key are loaded by ListenAndServeTLS.
+func (t *TLSConfig) tlsConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		MinVersion: tlsVersions[t.MinVersion],
+		ClientAuth: clientAuthTypes[t.ClientAuth],
+	}
+	if t.ClientCAFile != "" {
+		pool, err := loadCertPool(t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.ClientCAs = pool
+	}
+	return tlsCfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no pem certificates found in %s", path)
+	}
+	return pool, nil
+}
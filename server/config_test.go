@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The server starts without a config file, the default dbPath is relative to the working dir.
+func TestDefaultConfigValid(t *testing.T) {
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DBPath != "kvf.db" {
+		t.Errorf("dbPath = %q, want kvf.db", cfg.DBPath)
+	}
+}
+
+// config.example.json decodes, but its api key placeholders must be replaced before the server starts.
+func TestExampleConfigKeyPlaceholders(t *testing.T) {
+	_, err := loadConfig([]string{"-config", "config.example.json"})
+	if err == nil {
+		t.Fatal("example config with placeholder keyHash values is valid")
+	}
+	if n := strings.Count(err.Error(), "keyHash must be a hex sha256"); n != 3 {
+		t.Errorf("%d keyHash errors, want 3: %v", n, err)
+	}
+}
@@ -0,0 +1,100 @@
+// File health.go contains the liveness and readiness endpoints used by process supervisors and load balancers.
+//   GET /healthz - 200 if the server process is running and serving http, no db access
+//   GET /readyz  - 200 if the db is open and a read transaction succeeds, 503 otherwise
+// Readyz returns json with db file size, free pages and read-only mode. Neither endpoint requires an api key.
+// GET /readyz?check=true also runs the bolt integrity check (tx.Check), which reads every page of the db,
+// so it requires an api key with admin permission on "*" if apiKeys are configured.
+
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"kvfun/kvf"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const maxCheckErrors = 10 // integrity check errors reported by readyz
+
+// readyStatus is the /readyz response
+type readyStatus struct {
+	Ready        bool     `json:"ready"`
+	Error        string   `json:"error,omitempty"` // reason not ready
+	DBPath       string   `json:"dbPath"`
+	FileSize     int64    `json:"fileSize"`     // bytes, as seen by the read tx
+	FreePages    int      `json:"freePages"`    // pages on the freelist, available for reuse
+	PendingPages int      `json:"pendingPages"` // pages freed by a write tx, still in use by open read txs
+	ReadOnly     bool     `json:"readOnly"`
+	TxID         int      `json:"txId"`                  // id of last committed write tx
+	Buckets      int      `json:"buckets"`               // number of top level buckets
+	CheckErrors  []string `json:"checkErrors,omitempty"` // from integrity check, if requested
+	ProbeTime    string   `json:"probeTime"`             // time taken by the probe
+}
+
+// Func healthzHandler reports the process is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// Func readyzHandler reports whether the server can process db requests.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	check := r.URL.Query().Get("check") == "true"
+	if check && apiKeys != nil {
+		if key, err := requestKey(r); err != nil || !key.allows("*", permAdmin) {
+			slog.Info("readyz check rejected", "remote_addr", r.RemoteAddr)
+			writeError(w, r, kvf.CodeForbidden, "Integrity Check Requires Admin Api Key")
+			return
+		}
+	}
+	start := time.Now()
+	status := readyStatus{DBPath: cfg.DBPath}
+	if shuttingDown.Load() {
+		status.Error = "shutting down"
+	} else {
+		err := db.View(func(tx *bolt.Tx) error {
+			status.FileSize = tx.Size()
+			status.TxID = tx.ID()
+			tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				status.Buckets++
+				return nil
+			})
+			if check {
+				for err := range tx.Check() { // channel is closed when check is done, so drain it
+					if len(status.CheckErrors) < maxCheckErrors {
+						status.CheckErrors = append(status.CheckErrors, err.Error())
+					}
+				}
+			}
+			return nil
+		})
+		switch {
+		case err != nil:
+			status.Error = "db read tx failed - " + err.Error()
+		case len(status.CheckErrors) > 0:
+			status.Error = "db integrity check failed"
+		default:
+			status.Ready = true
+		}
+		stats := db.Stats()
+		status.FreePages = stats.FreePageN
+		status.PendingPages = stats.PendingPageN
+		status.ReadOnly = db.IsReadOnly()
+	}
+	status.ProbeTime = time.Since(start).String()
+
+	httpStatus := http.StatusOK
+	if !status.Ready {
+		slog.Warn("not ready", "err", status.Error)
+		httpStatus = http.StatusServiceUnavailable
+	}
+	data, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(httpStatus)
+	w.Write(data)
+}
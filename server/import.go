@@ -0,0 +1,318 @@
+// File import.go contains the /import endpoint, which loads an NDJSON export (see kvf/export.go) into the db.
+//   POST /import?mode=merge|replace&bkt=name&startKey=k&endKey=k    (bkt may be repeated)
+// The body is read line by line and recs are put in write txs of importBatchSize recs, so an import of any size
+// uses bounded memory and other writers are not blocked for the whole import. An import is not atomic, if it
+// fails part way the batches already committed remain, the Fail Response Msg says how far it got.
+// Mode replace deletes and recreates each imported bkt before its first batch.
+// Api key permissions (see auth.go): write on each bkt imported, admin to create a missing bkt or to replace one.
+// Imported recs and bkt creates/replaces are written to the change log like puts, see kvf/changelog.go.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"kvfun/kvf"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const importBatchSize = 10000 // recs per write tx
+
+// importBkt tracks the import of 1 bkt section of the export
+type importBkt struct {
+	header   *kvf.ExportHeader
+	skip     bool // not in ImportRequest.BktNames
+	started  bool // 1st batch committed, bkt has been created or replaced
+	lines    int  // rec lines read
+	imported int
+	skipped  int // outside the requested key range
+}
+
+type importRec struct {
+	key []byte
+	val []byte
+}
+
+// importer holds the state of 1 import request
+type importer struct {
+	req     kvf.ImportRequest
+	key     *apiKey // nil if authentication is disabled
+	bkts    []*importBkt
+	batch   []importRec
+	lineNum int
+}
+
+var errImportForbidden = errors.New("permission denied")
+
+// Func importHandler loads the export in the request body and sends a Response with the counts by bkt.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	r, _ = withRequestID(w, r, "import") // see logging.go
+	logger := reqLogger(r)
+	rm, w := startRequest("import", w, r) // see metrics.go
+	if timeout := cfg.Timeouts.opTimeout("import"); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	query := r.URL.Query()
+	im := &importer{req: kvf.ImportRequest{
+		BktNames: query["bkt"],
+		Mode:     query.Get("mode"),
+		StartKey: query.Get("startKey"),
+		EndKey:   query.Get("endKey"),
+	}}
+	response := im.run(w, r)
+	writeResponse(w, r, response)
+	elapsed := rm.done(response)
+	logger.Info("import done", "duration", elapsed, "status", statusLabel(response), "recs", response.PutCnt)
+	logSlowRequest(r, im.req, response, elapsed)
+}
+
+// Func run reads the export and imports it, the Response to send is returned.
+func (im *importer) run(w http.ResponseWriter, r *http.Request) *kvf.Response {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return failResponse(kvf.CodeMethodNotAllowed, "Method Not Allowed - use POST")
+	}
+	switch im.req.Mode {
+	case "":
+		im.req.Mode = kvf.ImportMerge
+	case kvf.ImportMerge, kvf.ImportReplace:
+	default:
+		return failResponse(kvf.CodeValidation, "Invalid Import Mode - "+im.req.Mode+", use merge or replace")
+	}
+	if apiKeys != nil {
+		key, err := requestKey(r)
+		if err != nil {
+			reqLogger(r).Info("request rejected", "err", err, "remote_addr", r.RemoteAddr)
+			return failResponse(kvf.CodeUnauthorized, "Unauthorized - "+err.Error())
+		}
+		im.key = key
+	}
+	body, err := kvf.NewDecompressReader(r.Header.Get("Content-Encoding"), r.Body)
+	if err != nil {
+		return failResponse(kvf.CodeUnsupported, "Unsupported Content-Encoding - "+r.Header.Get("Content-Encoding"))
+	}
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), importMaxLine())
+	for scanner.Scan() {
+		im.lineNum++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if code, msg := im.line(r.Context(), scanner.Bytes()); code != "" {
+			return im.failed(code, msg)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return im.failed(kvf.CodeTooLarge, fmt.Sprintf("line %d exceeds %d bytes", im.lineNum+1, importMaxLine()))
+		}
+		return im.failed(kvf.CodeBadRequest, "read failed - "+err.Error())
+	}
+	if code, msg := im.endBkt(r.Context()); code != "" {
+		return im.failed(code, msg)
+	}
+	return im.result()
+}
+
+// Func line processes 1 line of the export. A Code and Msg are returned if the import must stop.
+func (im *importer) line(ctx context.Context, data []byte) (string, string) {
+	var line kvf.ExportLine
+	if err := json.Unmarshal(data, &line); err != nil {
+		return kvf.CodeBadRequest, "invalid json - " + err.Error()
+	}
+	if line.Header != nil {
+		if code, msg := im.endBkt(ctx); code != "" {
+			return code, msg
+		}
+		return im.startBkt(line.Header)
+	}
+	if len(im.bkts) == 0 {
+		return kvf.CodeBadRequest, "rec before first header, not a kvf export"
+	}
+	cur := im.bkts[len(im.bkts)-1]
+	cur.lines++
+	if cur.skip {
+		return "", ""
+	}
+	if line.Key == "" {
+		return kvf.CodeValidation, "key is required"
+	}
+	if (im.req.StartKey != "" && line.Key < im.req.StartKey) || (im.req.EndKey != "" && line.Key > im.req.EndKey) {
+		cur.skipped++
+		return "", ""
+	}
+	val := []byte(line.Rec)
+	if line.Raw != nil {
+		val = line.Raw
+	}
+	if len(val) == 0 {
+		return kvf.CodeValidation, "rec is empty - key " + line.Key
+	}
+	if cfg.Limits.MaxRecBytes > 0 && len(val) > cfg.Limits.MaxRecBytes {
+		return kvf.CodeTooLarge, fmt.Sprintf("rec size %d bytes exceeds limit of %d - key %s", len(val), cfg.Limits.MaxRecBytes, line.Key)
+	}
+	im.batch = append(im.batch, importRec{key: []byte(line.Key), val: val})
+	if len(im.batch) == importBatchSize {
+		return im.flush(ctx, cur)
+	}
+	return "", ""
+}
+
+// Func startBkt begins a new bkt section.
+func (im *importer) startBkt(header *kvf.ExportHeader) (string, string) {
+	if header.Format != kvf.ExportFormat {
+		return kvf.CodeValidation, fmt.Sprintf("unsupported export format %q, expected %q", header.Format, kvf.ExportFormat)
+	}
+	if err := kvf.Validate(&kvf.BktRequest{BktName: header.BktName, Operation: "create"}, kvf.Limits{}); err != nil { // change log bkt is reserved
+		return kvf.CodeValidation, "invalid header - " + err.Error()
+	}
+	cur := &importBkt{header: header}
+	cur.skip = len(im.req.BktNames) > 0 && !slices.Contains(im.req.BktNames, header.BktName)
+	if !cur.skip && im.key != nil {
+		perm := permWrite
+		if im.req.Mode == kvf.ImportReplace {
+			perm = permAdmin
+		}
+		if !im.key.allows(header.BktName, perm) {
+			im.bkts = append(im.bkts, cur)
+			return kvf.CodeForbidden, "Permission Denied - import on bkt " + header.BktName
+		}
+	}
+	im.bkts = append(im.bkts, cur)
+	return "", ""
+}
+
+// Func endBkt commits the rest of the current bkt section.
+func (im *importer) endBkt(ctx context.Context) (string, string) {
+	if len(im.bkts) == 0 {
+		return "", ""
+	}
+	cur := im.bkts[len(im.bkts)-1]
+	if cur.skip || (cur.started && len(im.batch) == 0) {
+		return "", ""
+	}
+	return im.flush(ctx, cur) // also creates or replaces a bkt with no recs
+}
+
+// Func flush puts the batch into cur bkt in 1 write tx.
+// The 1st flush of a bkt creates it if missing, or replaces it in replace mode.
+func (im *importer) flush(ctx context.Context, cur *importBkt) (string, string) {
+	if err := ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return kvf.CodeTimeout, "Request Time Limit Exceeded"
+		}
+		return kvf.CodeCanceled, "Request Canceled"
+	}
+	name := []byte(cur.header.BktName)
+	err := db.Update(func(tx *bolt.Tx) error {
+		if !cur.started {
+			exists := tx.Bucket(name) != nil
+			if (!exists || im.req.Mode == kvf.ImportReplace) && im.key != nil && !im.key.allows(cur.header.BktName, permAdmin) {
+				return errImportForbidden
+			}
+			if exists && im.req.Mode == kvf.ImportReplace {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+				if err := kvf.LogChange(tx, kvf.ChangeBktDelete, cur.header.BktName, nil, nil, nil); err != nil {
+					return err
+				}
+				exists = false
+			}
+			if !exists {
+				if _, err := tx.CreateBucket(name); err != nil {
+					return err
+				}
+				if err := kvf.LogChange(tx, kvf.ChangeBktCreate, cur.header.BktName, nil, nil, nil); err != nil {
+					return err
+				}
+			}
+		}
+		bkt := tx.Bucket(name)
+		for _, rec := range im.batch {
+			before := kvf.ChangeBefore(bkt, rec.key)
+			if err := bkt.Put(rec.key, rec.val); err != nil {
+				return fmt.Errorf("put key %s: %w", rec.key, err)
+			}
+			if err := kvf.LogChange(tx, kvf.ChangePut, cur.header.BktName, rec.key, before, rec.val); err != nil {
+				return fmt.Errorf("change log: %w", err)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errImportForbidden) {
+		return kvf.CodeForbidden, "Permission Denied - admin required to create bkt " + cur.header.BktName
+	}
+	if err != nil {
+		slog.Error("import write failed", "bkt", cur.header.BktName, "err", err)
+		return kvf.CodeInternal, fmt.Sprintf("bkt %s - %s", cur.header.BktName, err)
+	}
+	kvf.NotifyChanges() // see kvf/watch.go
+	cur.started = true
+	cur.imported += len(im.batch)
+	im.batch = im.batch[:0]
+	return "", ""
+}
+
+// Func result returns the Ok Response, or a Warning if a bkt section had fewer or more recs than its header count.
+func (im *importer) result() *kvf.Response {
+	response := &kvf.Response{Status: kvf.Ok}
+	var msgs, warnings []string
+	for _, b := range im.bkts {
+		response.Count += b.lines
+		response.PutCnt += b.imported
+		if b.skip {
+			msgs = append(msgs, b.header.BktName+" skipped")
+			continue
+		}
+		msgs = append(msgs, fmt.Sprintf("%s %d imported, %d outside key range", b.header.BktName, b.imported, b.skipped))
+		if b.lines != b.header.Count {
+			warnings = append(warnings, fmt.Sprintf("%s header count %d, %d recs read", b.header.BktName, b.header.Count, b.lines))
+		}
+	}
+	response.Msg = "import " + im.req.Mode + " done - " + strings.Join(msgs, "; ")
+	if len(warnings) > 0 {
+		response.Status = kvf.Warning
+		response.Code = kvf.CodeValidation
+		response.Msg += " - export may be incomplete: " + strings.Join(warnings, "; ")
+	}
+	return response
+}
+
+// Func failed returns a Fail Response reporting the line and what was imported before it.
+func (im *importer) failed(code, msg string) *kvf.Response {
+	response := im.result()
+	response.Status = kvf.Fail
+	response.Code = code
+	committed := "nothing"
+	if response.PutCnt > 0 {
+		committed = strings.TrimPrefix(response.Msg, "import "+im.req.Mode+" done - ")
+	}
+	response.Msg = fmt.Sprintf("Import Failed at line %d - %s. Committed before failure: %s", im.lineNum, msg, committed)
+	return response
+}
+
+// Func importMaxLine returns the max export line size, allowing for base64 encoded raw recs.
+func importMaxLine() int {
+	if cfg.Limits.MaxRecBytes > 0 {
+		return cfg.Limits.MaxRecBytes*2 + 64*1024
+	}
+	return 64 << 20
+}
+
+func failResponse(code, msg string) *kvf.Response {
+	return &kvf.Response{Status: kvf.Fail, Code: code, Msg: msg}
+}
@@ -0,0 +1,109 @@
+// File logging.go sets up structured, leveled logging with log/slog.
+// All server and kvf handler messages go through the slog default logger, in text or json format (cfg.LogFormat).
+// Messages about a request include its request_id, taken from the kvf.RequestIDHeader request header
+// or generated if not sent. The id is echoed in the response header and Response.RequestID.
+// Requests taking longer than cfg.SlowRequest are logged at warn level with the full request.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"kvfun/kvf"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+var logFormats = map[string]bool{"text": true, "json": true}
+
+// Func setupLogging sets the slog default logger, also used by the standard log pkg.
+func setupLogging(level, format string) {
+	opts := &slog.HandlerOptions{Level: logLevels[strings.ToLower(level)]}
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// Func fatal logs msg at error level and exits, slog has no Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type loggerKey struct{}
+
+// Func withRequestID sets the request id response header and adds a logger with the id to the request context.
+// The id is limited to 128 printable chars, otherwise a new id is generated.
+func withRequestID(w http.ResponseWriter, r *http.Request, op string) (*http.Request, string) {
+	id := r.Header.Get(kvf.RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(kvf.RequestIDHeader, id)
+	logger := slog.Default().With("request_id", id, "op", op)
+	return r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger)), id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Func reqLogger returns the request logger added by withRequestID, or the default logger.
+func reqLogger(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Func logSlowRequest logs the full request if elapsed >= cfg.SlowRequest (0 disables).
+func logSlowRequest(r *http.Request, request any, response *kvf.Response, elapsed time.Duration) {
+	if cfg.SlowRequest <= 0 || elapsed < time.Duration(cfg.SlowRequest) {
+		return
+	}
+	args := []any{"duration", elapsed, "request", requestJSON(request)}
+	if response != nil {
+		args = append(args, "status", statusLabel(response), "scanned", response.Scanned(), "count", response.Count)
+	}
+	reqLogger(r).Warn("slow request", args...)
+}
+
+// Func requestJSON returns request as json for logging.
+func requestJSON(request any) string {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kvfun/kvf"
+)
+
+// A wrong http method is rejected with 405, CodeMethodNotAllowed and an Allow header, before any db access.
+func TestMethodNotAllowed(t *testing.T) {
+	defer func(c *Config) { cfg = c }(cfg)
+	cfg = &Config{AdminToken: "test-token"} // backup to file checks the method after authorizeAdmin
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		url     string
+		allow   string
+	}{
+		{"shutdown", adminShutdownHandler, http.MethodGet, "/admin/shutdown", "POST"},
+		{"backup", backupHandler, http.MethodPut, "/admin/backup", "GET, POST"},
+		{"backup to file", backupHandler, http.MethodGet, "/admin/backup?path=x.db", "POST"},
+		{"import", importHandler, http.MethodGet, "/import?bkt=location", "POST"},
+		{"watch", watchHandler, http.MethodDelete, "/watch", "GET, POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.url, nil)
+			r.Header.Set(kvf.AdminTokenHeader, cfg.AdminToken)
+			tt.handler(w, r)
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.allow {
+				t.Errorf("Allow = %q, want %q", allow, tt.allow)
+			}
+			var resp kvf.Response
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("body %q: %v", w.Body.String(), err)
+			}
+			if resp.Status != kvf.Fail || resp.Code != kvf.CodeMethodNotAllowed {
+				t.Errorf("status %d code %q, want Fail %q", resp.Status, resp.Code, kvf.CodeMethodNotAllowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+// File metrics.go serves request and bolt db metrics at /metrics in the Prometheus text format (version 0.0.4).
+// Request metrics are recorded by dbHandler for each op, db metrics are read from db.Stats() when scraped.
+// If apiKeys are configured, the scraper must send a valid key (any permission), ex. prometheus bearer_token.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kvfun/kvf"
+)
+
+// latencyBuckets are the request duration histogram upper bounds in seconds
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// opMetrics holds the metrics of 1 op, guarded by metrics.mu
+type opMetrics struct {
+	requests     map[string]uint64 // by status label, see statusLabel
+	buckets      []uint64          // count of requests <= latencyBuckets[i], not cumulative until written
+	durationSum  float64           // seconds
+	durationCnt  uint64
+	recsScanned  uint64
+	recsReturned uint64
+	recsWritten  uint64
+	bytesIn      uint64
+	bytesOut     uint64
+}
+
+var metrics = struct {
+	mu       sync.Mutex
+	ops      map[string]*opMetrics
+	inFlight atomic.Int64
+}{ops: make(map[string]*opMetrics)}
+
+// requestMetrics collects the values of 1 request, see dbHandler
+type requestMetrics struct {
+	op       string
+	start    time.Time
+	streamed bool
+	bytesIn  *countingReader
+	bytesOut *countingWriter
+}
+
+// Func startRequest wraps w and r.Body to count bytes and increments in-flight requests.
+// Call done when the request is complete.
+func startRequest(op string, w http.ResponseWriter, r *http.Request) (*requestMetrics, http.ResponseWriter) {
+	metrics.inFlight.Add(1)
+	rm := &requestMetrics{
+		op:       op,
+		start:    time.Now(),
+		bytesIn:  &countingReader{ReadCloser: r.Body},
+		bytesOut: &countingWriter{ResponseWriter: w},
+	}
+	r.Body = rm.bytesIn
+	return rm, rm.bytesOut
+}
+
+// Func done records request metrics and returns the request duration, response is nil if no Response was sent.
+func (rm *requestMetrics) done(response *kvf.Response) time.Duration {
+	duration := time.Since(rm.start)
+	elapsed := duration.Seconds()
+	metrics.inFlight.Add(-1)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	m := metrics.ops[rm.op]
+	if m == nil {
+		m = &opMetrics{requests: make(map[string]uint64), buckets: make([]uint64, len(latencyBuckets))}
+		metrics.ops[rm.op] = m
+	}
+	m.requests[statusLabel(response)]++
+	for i, le := range latencyBuckets {
+		if elapsed <= le {
+			m.buckets[i]++
+			break
+		}
+	}
+	m.durationSum += elapsed
+	m.durationCnt++
+	m.bytesIn += rm.bytesIn.n
+	m.bytesOut += rm.bytesOut.n
+	if response == nil {
+		return duration
+	}
+	m.recsScanned += uint64(response.Scanned())
+	m.recsWritten += uint64(response.PutCnt)
+	if rm.streamed {
+		m.recsReturned += uint64(response.Count)
+		return duration
+	}
+	m.recsReturned += uint64(len(response.Recs))
+	if response.Rec != nil {
+		m.recsReturned++
+	}
+	return duration
+}
+
+// Func statusLabel returns "ok", "warning" or the Fail Response.Code
+func statusLabel(response *kvf.Response) string {
+	switch {
+	case response == nil:
+		return kvf.CodeInternal
+	case response.Status == kvf.Ok:
+		return "ok"
+	case response.Status == kvf.Warning:
+		return "warning"
+	case response.Code == "":
+		return kvf.CodeInternal
+	}
+	return response.Code
+}
+
+// Func metricsHandler writes all metrics in Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if apiKeys != nil {
+		if _, err := requestKey(r); err != nil {
+			slog.Info("metrics request rejected", "err", err, "remote_addr", r.RemoteAddr)
+			writeError(w, r, kvf.CodeUnauthorized, "Unauthorized - "+err.Error())
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	writeRequestMetrics(bw)
+	writeDBMetrics(bw)
+	bw.Flush()
+}
+
+func writeRequestMetrics(w io.Writer) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	ops := make([]string, 0, len(metrics.ops))
+	for op := range metrics.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops) // stable output order
+
+	writeHeader(w, "kvf_requests_in_flight", "gauge", "Requests currently being processed.")
+	fmt.Fprintf(w, "kvf_requests_in_flight %d\n", metrics.inFlight.Load())
+
+	writeHeader(w, "kvf_requests_total", "counter", "Requests by op and result status (ok, warning or error code).")
+	for _, op := range ops {
+		m := metrics.ops[op]
+		statuses := make([]string, 0, len(m.requests))
+		for status := range m.requests {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(w, "kvf_requests_total{op=%q,status=%q} %d\n", op, status, m.requests[status])
+		}
+	}
+
+	writeHeader(w, "kvf_request_duration_seconds", "histogram", "Request duration by op.")
+	for _, op := range ops {
+		m := metrics.ops[op]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += m.buckets[i]
+			fmt.Fprintf(w, "kvf_request_duration_seconds_bucket{op=%q,le=\"%g\"} %d\n", op, le, cumulative)
+		}
+		fmt.Fprintf(w, "kvf_request_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, m.durationCnt)
+		fmt.Fprintf(w, "kvf_request_duration_seconds_sum{op=%q} %g\n", op, m.durationSum)
+		fmt.Fprintf(w, "kvf_request_duration_seconds_count{op=%q} %d\n", op, m.durationCnt)
+	}
+
+	counters := []struct {
+		name, help string
+		val        func(m *opMetrics) uint64
+	}{
+		{"kvf_records_scanned_total", "Records read from the db by op.", func(m *opMetrics) uint64 { return m.recsScanned }},
+		{"kvf_records_returned_total", "Records returned to clients by op.", func(m *opMetrics) uint64 { return m.recsReturned }},
+		{"kvf_records_written_total", "Records added or replaced by op.", func(m *opMetrics) uint64 { return m.recsWritten }},
+		{"kvf_request_bytes_total", "Request body bytes received by op, as sent (compressed if used).", func(m *opMetrics) uint64 { return m.bytesIn }},
+		{"kvf_response_bytes_total", "Response body bytes sent by op, as sent (compressed if used).", func(m *opMetrics) uint64 { return m.bytesOut }},
+	}
+	for _, c := range counters {
+		writeHeader(w, c.name, "counter", c.help)
+		for _, op := range ops {
+			fmt.Fprintf(w, "%s{op=%q} %d\n", c.name, op, c.val(metrics.ops[op]))
+		}
+	}
+}
+
+func writeDBMetrics(w io.Writer) {
+	stats := db.Stats()
+	tx := &stats.TxStats
+	gauges := []struct {
+		name, typ, help string
+		val             float64
+	}{
+		{"kvf_bolt_read_tx_total", "counter", "Read transactions started.", float64(stats.TxN)},
+		{"kvf_bolt_open_read_tx", "gauge", "Read transactions currently open.", float64(stats.OpenTxN)},
+		{"kvf_bolt_free_pages", "gauge", "Pages on the freelist.", float64(stats.FreePageN)},
+		{"kvf_bolt_pending_pages", "gauge", "Pages pending release to the freelist.", float64(stats.PendingPageN)},
+		{"kvf_bolt_free_alloc_bytes", "gauge", "Bytes allocated in free pages.", float64(stats.FreeAlloc)},
+		{"kvf_bolt_freelist_inuse_bytes", "gauge", "Bytes used by the freelist.", float64(stats.FreelistInuse)},
+		{"kvf_bolt_page_allocs_total", "counter", "Page allocations.", float64(tx.GetPageCount())},
+		{"kvf_bolt_page_alloc_bytes_total", "counter", "Bytes allocated for pages.", float64(tx.GetPageAlloc())},
+		{"kvf_bolt_cursors_total", "counter", "Cursors created.", float64(tx.GetCursorCount())},
+		{"kvf_bolt_node_allocs_total", "counter", "Node allocations.", float64(tx.GetNodeCount())},
+		{"kvf_bolt_node_derefs_total", "counter", "Node dereferences.", float64(tx.GetNodeDeref())},
+		{"kvf_bolt_rebalances_total", "counter", "Node rebalances.", float64(tx.GetRebalance())},
+		{"kvf_bolt_rebalance_seconds_total", "counter", "Time spent rebalancing.", tx.GetRebalanceTime().Seconds()},
+		{"kvf_bolt_splits_total", "counter", "Nodes split.", float64(tx.GetSplit())},
+		{"kvf_bolt_spills_total", "counter", "Nodes spilled.", float64(tx.GetSpill())},
+		{"kvf_bolt_spill_seconds_total", "counter", "Time spent spilling.", tx.GetSpillTime().Seconds()},
+		{"kvf_bolt_writes_total", "counter", "Writes performed.", float64(tx.GetWrite())},
+		{"kvf_bolt_write_seconds_total", "counter", "Time spent writing to disk.", tx.GetWriteTime().Seconds()},
+	}
+	for _, g := range gauges {
+		writeHeader(w, g.name, g.typ, g.help)
+		fmt.Fprintf(w, "%s %g\n", g.name, g.val)
+	}
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// countingReader counts request body bytes read
+type countingReader struct {
+	io.ReadCloser
+	n uint64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += uint64(n)
+	return n, err
+}
+
+// countingWriter counts response body bytes written
+type countingWriter struct {
+	http.ResponseWriter
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
+
+// Func Unwrap lets http.ResponseController reach the http.ResponseWriter, ex. to flush watch events.
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
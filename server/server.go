@@ -1,32 +1,65 @@
 // Program server.go accepts http requests from client programs and interacts with the bolt db.
 // All requests use the Post method.
-// All responses are instances of *kvf.Response.
+// All responses are instances of *kvf.Response, encoded with the codec requested by the client (see kvf/codec.go).
+// Failed requests are sent with the http status matching Response.Code (see kvf/errors.go).
 // The dbHandler func calls appropriate request handler in handlers.go.
+// Settings (db path, listen address, tls, bolt options, limits, api keys) are loaded by config.go.
+// Requests are checked against the api key permissions in auth.go.
+// Each kvf handler gets the request context, done when the client goes away or the op time limit (cfg.Timeouts) is reached.
 
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"flag"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"kvfun/kvf"
 
 	bolt "go.etcd.io/bbolt"
 )
 
-var dbPath = "/home/jay/data/kvftest.db"
+var cfg *Config // loaded at startup, see config.go
 var db *bolt.DB
 
+var errBodyTooLarge = errors.New("request body too large")
+var errRequestFailed = errors.New("request failed") // rolls back a write tx, see writeTx
+
 func main() {
 	var err error
 
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	cfg, err = loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("invalid config", "err", err)
+	}
+	setupLogging(cfg.LogLevel, cfg.LogFormat) // see logging.go
+	kvf.MaxQryParallel = cfg.Limits.MaxQryParallel
+	kvf.ChangeLog = cfg.ChangeLog.Enabled // see kvf/changelog.go
+	kvf.ChangeLogValues = cfg.ChangeLog.Values
+	kvf.ChangeLogMax = cfg.ChangeLog.MaxEntries
+	loadAPIKeys(cfg.APIKeys)
 
-	db, err = bolt.Open(dbPath, 0600, nil)
+	db, err = bolt.Open(cfg.DBPath, 0600, cfg.boltOptions())
 	if err != nil {
-		log.Fatal(err)
+		fatal("db open failed", "path", cfg.DBPath, "err", err)
+	}
+	slog.Info("db opened", "path", cfg.DBPath)
+	if cfg.Bolt.BatchWrites {
+		db.MaxBatchSize = cfg.Bolt.MaxBatchSize
+		db.MaxBatchDelay = time.Duration(cfg.Bolt.MaxBatchDelay)
+		slog.Info("batch writes enabled", "maxBatchSize", db.MaxBatchSize, "maxBatchDelay", db.MaxBatchDelay)
 	}
 	http.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
 		var request kvf.GetRequest
@@ -56,82 +89,425 @@ func main() {
 		var request kvf.QryRequest
 		dbHandler("qry", &request, w, r)
 	})
+	http.HandleFunc("/qryexport", func(w http.ResponseWriter, r *http.Request) {
+		var request kvf.QryExportRequest
+		dbHandler("qryexport", &request, w, r)
+	})
 	http.HandleFunc("/bkt", func(w http.ResponseWriter, r *http.Request) {
 		var request kvf.BktRequest
 		dbHandler("bkt", &request, w, r)
 	})
-	http.HandleFunc("/close", func(w http.ResponseWriter, r *http.Request) {
-		if err := db.Close(); err != nil {
-			log.Fatal(err)
-		}
-		log.Println("db closed")
+	http.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		var request kvf.ChangesRequest
+		dbHandler("changes", &request, w, r)
+	})
+	http.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		var request kvf.ExportRequest
+		dbHandler("export", &request, w, r)
 	})
+	http.HandleFunc("/import", importHandler)                // body is an NDJSON export, not a request, see import.go
+	http.HandleFunc("/watch", watchHandler)                  // Server-Sent Events, see watch.go
+	http.HandleFunc("/admin/shutdown", adminShutdownHandler) // replaces /close, see shutdown.go
+	http.HandleFunc("/admin/backup", backupHandler)          // see backup.go
+	http.HandleFunc("/metrics", metricsHandler)              // see metrics.go
+	http.HandleFunc("/healthz", healthzHandler)              // see health.go
+	http.HandleFunc("/readyz", readyzHandler)
+
+	srv := &http.Server{Addr: cfg.Addr} // uses http.DefaultServeMux
+	if cfg.TLS.enabled() {
+		srv.TLSConfig, err = cfg.TLS.tlsConfig()
+		if err != nil {
+			fatal("tls config failed", "err", err)
+		}
+	}
+	srv.RegisterOnShutdown(stopWatches) // see watch.go
+	done := handleShutdown(srv)
+
+	if cfg.TLS.enabled() {
+		slog.Info("listening (https)", "addr", cfg.Addr, "client_certificates", clientAuthTxt(srv.TLSConfig.ClientAuth))
+		err = srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	} else {
+		slog.Info("listening", "addr", cfg.Addr)
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		fatal("http server failed", "err", err)
+	}
+	<-done // wait for in-flight requests to finish and db to close
+}
 
-	log.Println(http.ListenAndServe(":8000", nil))
+func clientAuthTxt(clientAuth tls.ClientAuthType) string {
+	switch clientAuth {
+	case tls.RequireAndVerifyClientCert:
+		return "required"
+	case tls.VerifyClientCertIfGiven:
+		return "verified if given"
+	}
+	return "not used"
 }
 
 func dbHandler(op string, request any, w http.ResponseWriter, r *http.Request) {
-	log.Println("request started")
-	jsonContent, err := io.ReadAll(r.Body) // -> []byte
-	if err != nil {
-		log.Println("readall of request body failed", op, err)
-		return
+	r, _ = withRequestID(w, r, op) // see logging.go
+	logger := reqLogger(r)
+	logger.Debug("request started")
+	rm, w := startRequest(op, w, r) // see metrics.go
+	if timeout := cfg.Timeouts.opTimeout(op); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
 	}
-	err = json.Unmarshal(jsonContent, request)
-	if err != nil {
-		log.Println("json.Unmarshal failed", op, err)
-		log.Println(string(jsonContent))
-		return
+	response, streamed := handleRequest(op, request, w, r)
+	rm.streamed = streamed
+	elapsed := rm.done(response)
+	logger.Debug("request done", "duration", elapsed)
+	logSlowRequest(r, request, response, elapsed)
+}
+
+// Func handleRequest decodes the request, calls the kvf handler and sends the response.
+// The Response sent is returned, streamed is true if recs were sent as NDJSON.
+func handleRequest(op string, request any, w http.ResponseWriter, r *http.Request) (response *kvf.Response, streamed bool) {
+	if rejected := decodeRequest(op, request, w, r); rejected != nil {
+		return rejected, false
 	}
-	var response *kvf.Response
+	if isStreamRequest(request) {
+		return streamHandler(op, request, w, r)
+	}
+	var err error
 	switch op {
 	case "get":
-		db.View(func(tx *bolt.Tx) error {
-			response = kvf.Get(tx, request.(*kvf.GetRequest))
+		err = db.View(func(tx *bolt.Tx) error {
+			response = kvf.Get(r.Context(), tx, request.(*kvf.GetRequest))
 			return nil
 		})
 	case "getone":
-		db.View(func(tx *bolt.Tx) error {
-			response = kvf.GetOne(tx, request.(*kvf.GetOneRequest))
+		err = db.View(func(tx *bolt.Tx) error {
+			response = kvf.GetOne(r.Context(), tx, request.(*kvf.GetOneRequest))
 			return nil
 		})
 	case "getall":
-		db.View(func(tx *bolt.Tx) error {
-			response = kvf.GetAll(tx, request.(*kvf.GetAllRequest))
+		err = db.View(func(tx *bolt.Tx) error {
+			response = kvf.GetAll(r.Context(), tx, request.(*kvf.GetAllRequest))
 			return nil
 		})
 	case "put":
-		db.Update(func(tx *bolt.Tx) error {
-			response = kvf.Put(tx, request.(*kvf.PutRequest))
-			return nil
+		response, err = writeTx(func(tx *bolt.Tx) *kvf.Response {
+			return kvf.Put(r.Context(), tx, request.(*kvf.PutRequest))
 		})
 	case "delete":
-		db.Update(func(tx *bolt.Tx) error {
-			response = kvf.Delete(tx, request.(*kvf.DeleteRequest))
-			return nil
+		response, err = writeTx(func(tx *bolt.Tx) *kvf.Response {
+			return kvf.Delete(r.Context(), tx, request.(*kvf.DeleteRequest))
 		})
 	case "putone":
-		db.Update(func(tx *bolt.Tx) error {
-			response = kvf.PutOne(tx, request.(*kvf.PutOneRequest))
-			return nil
+		response, err = writeTx(func(tx *bolt.Tx) *kvf.Response {
+			return kvf.PutOne(r.Context(), tx, request.(*kvf.PutOneRequest))
 		})
 	case "qry":
-		db.View(func(tx *bolt.Tx) error {
-			response = kvf.Qry(tx, request.(*kvf.QryRequest))
+		err = db.View(func(tx *bolt.Tx) error {
+			response = kvf.Qry(r.Context(), tx, request.(*kvf.QryRequest))
 			return nil
 		})
 	case "bkt":
-		db.Update(func(tx *bolt.Tx) error {
-			response = kvf.Bkt(tx, request.(*kvf.BktRequest))
+		err = db.Update(func(tx *bolt.Tx) error {
+			response = kvf.Bkt(r.Context(), tx, request.(*kvf.BktRequest))
+			if response.Status == kvf.Fail { // ex. change log write failed after the bkt op
+				return errRequestFailed
+			}
 			return nil
 		})
+		if errors.Is(err, errRequestFailed) {
+			err = nil
+		} else if err == nil {
+			kvf.NotifyChanges()
+		}
+	case "changes":
+		err = db.View(func(tx *bolt.Tx) error {
+			response = kvf.Changes(r.Context(), tx, request.(*kvf.ChangesRequest)) // see kvf/changelog.go
+			return nil
+		})
+	}
+	if err != nil { // tx begin or commit failed, handler response (if any) is not valid
+		reqLogger(r).Error("db tx failed", "err", err)
+		response = &kvf.Response{Status: kvf.Fail, Code: kvf.CodeInternal, Msg: "DB Transaction Failed - " + err.Error()}
+	}
+	writeResponse(w, r, response)
+	return response, false
+}
+
+// Func decodeRequest checks the api key, reads the request body into request, then checks the api key permissions
+// and request values. If the request cannot be decoded or is not allowed, a Fail Response is sent and returned,
+// otherwise nil is returned.
+func decodeRequest(op string, request any, w http.ResponseWriter, r *http.Request) *kvf.Response {
+	if rejected := authenticate(w, r); rejected != nil { // see auth.go
+		return rejected
+	}
+	if cfg.Limits.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.Limits.MaxBodyBytes)
+	}
+	body, err := kvf.NewDecompressReader(r.Header.Get("Content-Encoding"), r.Body)
+	if err != nil {
+		reqLogger(r).Info("unsupported request Content-Encoding", "encoding", r.Header.Get("Content-Encoding"), "err", err)
+		return writeError(w, r, kvf.CodeUnsupported, "Unsupported Content-Encoding - "+r.Header.Get("Content-Encoding"))
+	}
+	defer body.Close()
+	content, err := readBody(body, cfg.Limits.MaxBodyBytes) // -> []byte
+	if err != nil {
+		reqLogger(r).Info("read of request body failed", "err", err)
+		var maxErr *http.MaxBytesError
+		if errors.Is(err, errBodyTooLarge) || errors.As(err, &maxErr) {
+			return writeError(w, r, kvf.CodeTooLarge, "Request Body Too Large - max "+strconv.FormatInt(cfg.Limits.MaxBodyBytes, 10)+" bytes")
+		}
+		return writeError(w, r, kvf.CodeBadRequest, "Read Request Body Failed - "+err.Error())
+	}
+	codec, found := kvf.CodecFor(r.Header.Get("Content-Type"))
+	if !found {
+		reqLogger(r).Info("unsupported request Content-Type", "content_type", r.Header.Get("Content-Type"))
+		return writeError(w, r, kvf.CodeUnsupported, "Unsupported Content-Type - "+r.Header.Get("Content-Type"))
+	}
+	err = codec.Unmarshal(content, request)
+	if err != nil {
+		reqLogger(r).Info("request unmarshal failed", "content_type", codec.ContentType(), "err", err)
+		reqLogger(r).Debug("request body", "body", string(content))
+		return writeError(w, r, kvf.CodeBadRequest, "Request Decode Failed - "+err.Error())
+	}
+	return checkRequest(op, request, w, r)
+}
+
+// Func checkRequest checks the api key permissions and request values of a decoded request, see decodeRequest.
+func checkRequest(op string, request any, w http.ResponseWriter, r *http.Request) *kvf.Response {
+	if rejected := authorize(w, r, op, request); rejected != nil { // see auth.go
+		return rejected
+	}
+	var invalid *kvf.Error
+	if errors.As(kvf.Validate(request, cfg.Limits.kvfLimits()), &invalid) { // see kvf/validate.go
+		reqLogger(r).Info("request rejected", "code", invalid.Code, "err", invalid.Msg)
+		return writeError(w, r, invalid.Code, "Invalid Request - "+invalid.Msg)
+	}
+	return nil
+}
+
+// Func writeTx runs a Put, PutOne or Delete handler in a write tx and returns its Response.
+// If cfg.Bolt.BatchWrites is set, db.Batch is used so concurrent writers share 1 commit (and fsync).
+// Batch may call fn more than once, so fn must only depend on its request.
+// A Fail Response rolls back the tx, so a failed request never commits part of its writes.
+// In a batch, the rollback includes the other requests, bolt then reruns the failed fn on its own.
+func writeTx(fn func(tx *bolt.Tx) *kvf.Response) (*kvf.Response, error) {
+	var response *kvf.Response
+	txFn := func(tx *bolt.Tx) error {
+		response = fn(tx)
+		if response.Status == kvf.Fail {
+			response.PutCnt = 0 // recs put before the failure are rolled back
+			return errRequestFailed
+		}
+		return nil
+	}
+	var err error
+	if cfg.Bolt.BatchWrites {
+		err = db.Batch(txFn)
+	} else {
+		err = db.Update(txFn)
 	}
-	jsonData, err := json.Marshal(response) // if sending response to remote requester, then compression is probably a good idea
+	if errors.Is(err, errRequestFailed) { // response holds the failure
+		return response, nil
+	}
+	if err == nil {
+		kvf.NotifyChanges() // see kvf/watch.go
+	}
+	return response, err
+}
+
+// Func writeResponse sends response with the http status matching response.Code (see kvf/errors.go).
+func writeResponse(w http.ResponseWriter, r *http.Request, response *kvf.Response) {
+	response.RequestID = w.Header().Get(kvf.RequestIDHeader) // set by withRequestID, see logging.go
+	data, err := marshalResponse(w, r, response)
 	if err != nil {
-		log.Println("json.Marshal response failed", err)
-		log.Println(response)
+		reqLogger(r).Error("response marshal failed", "err", err, "response", response)
+		http.Error(w, "response marshal failed", http.StatusInternalServerError)
 		return
 	}
-	w.Write(jsonData)
-	log.Println("request done")
+	writeBody(w, r, kvf.HTTPStatus(response), data)
+}
+
+// Func writeError sends a Fail response for failures that occur before a request handler is called.
+// The Response sent is returned.
+func writeError(w http.ResponseWriter, r *http.Request, code, msg string) *kvf.Response {
+	response := &kvf.Response{Status: kvf.Fail, Code: code, Msg: msg}
+	writeResponse(w, r, response)
+	return response
+}
+
+// Func readBody reads the (decompressed) request body.
+// If maxBytes > 0, decompressed size is also limited, so a small compressed body cannot expand without limit.
+func readBody(body io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		return io.ReadAll(body)
+	}
+	content, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err == nil && int64(len(content)) > maxBytes {
+		err = errBodyTooLarge
+	}
+	return content, err
+}
+
+// Func writeBody compresses data if client sent a supported Accept-Encoding and data size >= cfg.CompressMinSize.
+func writeBody(w http.ResponseWriter, r *http.Request, status int, data []byte) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := kvf.AcceptEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding != "" && len(data) >= cfg.CompressMinSize {
+		compressed, err := kvf.Compress(encoding, data)
+		if err == nil {
+			w.Header().Set("Content-Encoding", encoding)
+			data = compressed
+		} else {
+			reqLogger(r).Warn("response compress failed, sending uncompressed", "encoding", encoding, "err", err)
+		}
+	}
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Func marshalResponse encodes response with the codec listed in the request Accept header,
+// or the request Content-Type codec if none is listed (see kvf/codec.go).
+// For json, the api version requested by the client (kvf.VersionHeader) is used.
+// Version2 clients receive kvf.RawResponse with recs embedded as raw json.
+// Clients not sending the header receive Version1, Response with base64 encoded recs.
+func marshalResponse(w http.ResponseWriter, r *http.Request, response *kvf.Response) ([]byte, error) {
+	reqCodec, found := kvf.CodecFor(r.Header.Get("Content-Type"))
+	if !found {
+		reqCodec = kvf.JSONCodec
+	}
+	codec := kvf.AcceptCodec(r.Header.Get("Accept"), reqCodec)
+	w.Header().Set("Content-Type", codec.ContentType())
+	if codec != kvf.JSONCodec {
+		return codec.Marshal(response)
+	}
+	version, _ := strconv.Atoi(r.Header.Get(kvf.VersionHeader))
+	if version >= kvf.Version2 {
+		w.Header().Set(kvf.VersionHeader, strconv.Itoa(kvf.Version2))
+		return json.Marshal(response.Raw())
+	}
+	return json.Marshal(response)
+}
+
+// Func isStreamRequest returns true for GetAll and Qry requests with Stream set, and for export and qryexport.
+func isStreamRequest(request any) bool {
+	switch req := request.(type) {
+	case *kvf.GetAllRequest:
+		return req.Stream
+	case *kvf.QryRequest:
+		return req.Stream
+	case *kvf.ExportRequest, *kvf.QryExportRequest:
+		return true
+	}
+	return false
+}
+
+// Func streamHandler writes recs as NDJSON while the read tx is open (see kvf/stream.go).
+// Response Status, Msg and Count are sent as http trailers after the last rec.
+// If the handler fails before any rec is written, a regular json Response is sent instead.
+// The Response is returned, streamed is false if the regular Response was sent.
+func streamHandler(op string, request any, w http.ResponseWriter, r *http.Request) (*kvf.Response, bool) {
+	sw := &streamWriter{w: w, encoding: kvf.AcceptEncoding(r.Header.Get("Accept-Encoding")), contentType: kvf.NDJSONContentType}
+	if req, ok := request.(*kvf.QryExportRequest); ok {
+		sw.contentType = req.ContentType()
+		sw.filename = req.Qry.BktName + ".csv"
+		if req.Format == kvf.FormatNDJSON {
+			sw.filename = req.Qry.BktName + ".ndjson"
+		}
+	}
+	bw := bufio.NewWriterSize(sw, 32*1024)
+	var response *kvf.Response
+	err := db.View(func(tx *bolt.Tx) error {
+		switch op {
+		case "getall":
+			response = kvf.GetAllStream(r.Context(), tx, request.(*kvf.GetAllRequest), bw)
+		case "qry":
+			response = kvf.QryStream(r.Context(), tx, request.(*kvf.QryRequest), bw)
+		case "export":
+			response = kvf.Export(r.Context(), tx, request.(*kvf.ExportRequest), bw) // see kvf/export.go
+		case "qryexport":
+			response = kvf.QryExport(r.Context(), tx, request.(*kvf.QryExportRequest), bw) // see kvf/qryexport.go
+			if response.Status == kvf.Ok && !sw.started {
+				// empty result (ndjson, or csv with noHeader) is an empty file, not a json Response
+				if err := sw.start(); err != nil {
+					reqLogger(r).Warn("stream start failed", "err", err)
+				}
+			}
+		}
+		err := bw.Flush()
+		if err == nil {
+			err = sw.Close()
+		}
+		if err != nil && response.Status == kvf.Ok {
+			reqLogger(r).Warn("stream flush failed", "err", err)
+			response.Status = kvf.Fail
+			response.Code = kvf.CodeInternal
+			response.Msg = "Stream Write Failed - " + err.Error()
+		}
+		return nil
+	})
+	if err != nil { // read tx could not be started
+		reqLogger(r).Error("db tx failed", "err", err)
+		response = &kvf.Response{Status: kvf.Fail, Code: kvf.CodeInternal, Msg: "DB Transaction Failed - " + err.Error()}
+	}
+	if !sw.started { // nothing written yet, so headers (and http status) can still be changed
+		writeResponse(w, r, response)
+		return response, false
+	}
+	w.Header().Set(kvf.TrailerStatus, strconv.Itoa(response.Status))
+	w.Header().Set(kvf.TrailerCode, response.Code)
+	w.Header().Set(kvf.TrailerMsg, response.Msg)
+	w.Header().Set(kvf.TrailerCount, strconv.Itoa(response.Count))
+	return response, true
+}
+
+// streamWriter sets the response headers (NDJSON, or csv for qryexport), including declared trailers, before the 1st write.
+// If encoding is set, the stream is compressed (no size threshold, size is not known in advance).
+type streamWriter struct {
+	w           http.ResponseWriter
+	encoding    string // from request Accept-Encoding, "" for no compression
+	contentType string
+	filename    string         // if set, sent as Content-Disposition attachment, ex. qryexport downloads
+	zw          io.WriteCloser // compressor, created on 1st write
+	started     bool
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if !sw.started {
+		if err := sw.start(); err != nil {
+			return 0, err
+		}
+	}
+	if sw.zw != nil {
+		return sw.zw.Write(p)
+	}
+	return sw.w.Write(p)
+}
+
+// Func start sets the response headers and sends the http status, called by the 1st Write.
+func (sw *streamWriter) start() error {
+	sw.started = true
+	sw.w.Header().Set("Content-Type", sw.contentType)
+	if sw.filename != "" {
+		sw.w.Header().Set("Content-Disposition", `attachment; filename="`+sw.filename+`"`)
+	}
+	sw.w.Header().Set("Trailer", kvf.TrailerStatus+", "+kvf.TrailerCode+", "+kvf.TrailerMsg+", "+kvf.TrailerCount)
+	sw.w.Header().Add("Vary", "Accept-Encoding")
+	if sw.encoding != "" {
+		zw, err := kvf.NewCompressWriter(sw.encoding, sw.w)
+		if err != nil {
+			return err
+		}
+		sw.zw = zw
+		sw.w.Header().Set("Content-Encoding", sw.encoding)
+	}
+	sw.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// Func Close flushes the compressor, if any. It does not close the http response.
+func (sw *streamWriter) Close() error {
+	if sw.zw != nil {
+		return sw.zw.Close()
+	}
+	return nil
 }
@@ -0,0 +1,69 @@
+// File shutdown.go contains graceful shutdown of the server program.
+// Shutdown starts on SIGINT/SIGTERM or an authorized POST to /admin/shutdown.
+// New connections are refused, in-flight requests are allowed to finish (up to cfg.ShutdownTimeout),
+// then the bolt db is closed. Closing the db while requests were running is no longer possible.
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"kvfun/kvf"
+)
+
+var adminShutdown = make(chan struct{}, 1) // signaled by adminShutdownHandler
+
+var shuttingDown atomic.Bool // set when shutdown starts, /readyz then reports not ready
+
+// Func handleShutdown waits for a signal or admin shutdown request, then drains srv and closes db.
+// The returned channel is closed once the db is closed.
+func handleShutdown(srv *http.Server) <-chan struct{} {
+	done := make(chan struct{})
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(done)
+		defer stop()
+		select {
+		case <-sigCtx.Done():
+			slog.Info("shutdown signal received")
+		case <-adminShutdown:
+			slog.Info("admin shutdown requested")
+		}
+		shuttingDown.Store(true)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil { // waits for in-flight requests
+			slog.Warn("http server shutdown did not complete, closing db anyway", "err", err)
+		}
+		if err := db.Close(); err != nil { // waits for any open transactions
+			slog.Error("db close failed", "err", err)
+			return
+		}
+		slog.Info("db closed")
+	}()
+	return done
+}
+
+// Func adminShutdownHandler starts graceful shutdown if the request is authorized by authorizeAdmin.
+func adminShutdownHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, r, kvf.CodeMethodNotAllowed, "Method Not Allowed - use POST")
+		return
+	}
+	if !authorizeAdmin(w, r, "Shutdown") { // see auth.go
+		return
+	}
+	select {
+	case adminShutdown <- struct{}{}:
+	default: // shutdown already requested
+	}
+	writeResponse(w, r, &kvf.Response{Status: kvf.Ok, Msg: "shutdown started"})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kvfun/certgen/certs"
+	"kvfun/kvf"
+)
+
+// Func writeTestCerts generates certificates with the certgen code and writes them to a temp dir.
+// Files are named as written by certgen, ex. ca.pem, server.pem, client-key.pem.
+func writeTestCerts(t *testing.T) string {
+	t.Helper()
+	set, err := certs.Generate([]string{"127.0.0.1"}, "test-client", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, cert := range map[string]*certs.Cert{"ca": set.CA, "server": set.Server, "client": set.Client} {
+		if err := cert.Write(dir, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Func startTLSServer starts an https test server using the tls.Config built from tc.
+func startTLSServer(t *testing.T, tc *TLSConfig) *httptest.Server {
+	t.Helper()
+	if errs := tc.validate(); errs != nil {
+		t.Fatal(errs)
+	}
+	tlsCfg, err := tc.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile) // loaded by ListenAndServeTLS in the server
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCfg.Certificates = []tls.Certificate{cert}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = tlsCfg
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	dir := writeTestCerts(t)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name       string
+		clientAuth string
+		withCert   bool
+		wantErr    bool
+	}{
+		{"require rejects no cert", "require", false, true},
+		{"require accepts client cert", "require", true, false},
+		{"verify-if-given accepts no cert", "verify-if-given", false, false},
+		{"verify-if-given accepts client cert", "verify-if-given", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startTLSServer(t, &TLSConfig{
+				CertFile:     file("server.pem"),
+				KeyFile:      file("server-key.pem"),
+				ClientCAFile: file("ca.pem"),
+				ClientAuth:   tt.clientAuth,
+			})
+			certFile, keyFile := "", ""
+			if tt.withCert {
+				certFile, keyFile = file("client.pem"), file("client-key.pem")
+			}
+			client, err := kvf.NewTLSClient(file("ca.pem"), certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Get(srv.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("request without client certificate succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want 200", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// A client certificate signed by another CA is rejected by verify-if-given, only a missing cert is allowed.
+func TestTLSClientCertOtherCA(t *testing.T) {
+	dir := writeTestCerts(t)
+	otherDir := writeTestCerts(t)
+	srv := startTLSServer(t, &TLSConfig{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		ClientAuth:   "verify-if-given",
+	})
+	client, err := kvf.NewTLSClient(filepath.Join(dir, "ca.pem"), filepath.Join(otherDir, "client.pem"), filepath.Join(otherDir, "client-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("request with client certificate from another CA succeeded")
+	}
+}
+
+func TestNewTLSClientBadCA(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte("not pem"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvf.NewTLSClient(path, "", ""); err == nil {
+		t.Error("NewTLSClient with invalid CA file returned no error")
+	}
+}
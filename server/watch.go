@@ -0,0 +1,167 @@
+// File watch.go contains the /watch endpoint, which sends change log entries (see kvf/changelog.go) as
+// Server-Sent Events while they are committed, filtered by bkt, key prefix and FindConditions (see kvf/watch.go).
+//   POST /watch      - body is a kvf.WatchRequest, used by kvf.RunWatch
+//   GET  /watch?bktName=location&keyPrefix=k01&fromSeq=42&findConditions=[{"Fld":"st","Op":1,"ValStr":"PA"}]
+//                    - for browser EventSource, all params are optional
+// A Last-Event-ID header (sent by EventSource when it reconnects) replaces fromSeq with the id + 1.
+// Each watch waits on kvf.ChangeSignal, so an idle watch uses no db tx. A comment line is sent every watchPing
+// so proxies keep the connection open. Watches end when the client goes away or the server shuts down.
+// Needs the change log (changeLog.enabled) and read permission on the bkt, all bkts (read on "*") if bktName is not set.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"kvfun/kvf"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const watchPing = 15 * time.Second
+
+// watchStop is closed when server shutdown starts, so open watches end and srv.Shutdown does not wait for them.
+var watchStop = make(chan struct{})
+var stopWatchesOnce sync.Once
+
+// Func stopWatches is registered with srv.RegisterOnShutdown.
+func stopWatches() {
+	stopWatchesOnce.Do(func() { close(watchStop) })
+}
+
+// Func watchHandler decodes the WatchRequest from the body (POST) or the query params (GET), then streams events.
+func watchHandler(w http.ResponseWriter, r *http.Request) {
+	r, _ = withRequestID(w, r, "watch") // see logging.go
+	logger := reqLogger(r)
+	rm, w := startRequest("watch", w, r) // see metrics.go
+	if timeout := cfg.Timeouts.opTimeout("watch"); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	var request kvf.WatchRequest
+	var response *kvf.Response
+	switch r.Method {
+	case http.MethodPost:
+		response = decodeRequest("watch", &request, w, r)
+	case http.MethodGet:
+		response = queryWatchRequest(&request, w, r)
+		if response == nil {
+			response = checkRequest("watch", &request, w, r)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		response = writeError(w, r, kvf.CodeMethodNotAllowed, "Method Not Allowed - use GET or POST")
+	}
+	if response == nil {
+		response, rm.streamed = watch(&request, w, r)
+	}
+	elapsed := rm.done(response)
+	logger.Debug("watch done", "duration", elapsed, "events", response.Count)
+}
+
+// Func queryWatchRequest loads request from the GET query params, a Fail Response is sent and returned if they are invalid.
+func queryWatchRequest(request *kvf.WatchRequest, w http.ResponseWriter, r *http.Request) *kvf.Response {
+	query := r.URL.Query()
+	request.BktName = query.Get("bktName")
+	request.KeyPrefix = query.Get("keyPrefix")
+	if fromSeq := query.Get("fromSeq"); fromSeq != "" {
+		seq, err := strconv.ParseUint(fromSeq, 10, 64)
+		if err != nil {
+			return writeError(w, r, kvf.CodeBadRequest, "Invalid fromSeq - "+fromSeq)
+		}
+		request.FromSeq = seq
+	}
+	if conditions := query.Get("findConditions"); conditions != "" {
+		if err := json.Unmarshal([]byte(conditions), &request.FindConditions); err != nil {
+			return writeError(w, r, kvf.CodeBadRequest, "Invalid findConditions - "+err.Error())
+		}
+	}
+	return nil
+}
+
+// Func watch sends events until the client goes away, the op time limit is reached or the server shuts down.
+// The returned Response has the number of events sent in Count, streamed is false if a regular Response was sent.
+func watch(request *kvf.WatchRequest, w http.ResponseWriter, r *http.Request) (*kvf.Response, bool) {
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		if seq, err := strconv.ParseUint(lastID, 10, 64); err == nil {
+			request.FromSeq = seq + 1
+		}
+	}
+	var watcher *kvf.Watcher
+	err := db.View(func(tx *bolt.Tx) error {
+		watcher = kvf.NewWatcher(tx, request)
+		return nil
+	})
+	if err != nil {
+		reqLogger(r).Error("db tx failed", "err", err)
+		return writeError(w, r, kvf.CodeInternal, "DB Transaction Failed - "+err.Error()), false
+	}
+	defer watcher.Release()
+	reqLogger(r).Debug("watch started", "seq", watcher.Seq)
+
+	w.Header().Set("Content-Type", kvf.WatchContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx, do not buffer events
+	w.Header().Set(kvf.WatchSeqHeader, strconv.FormatUint(watcher.Seq, 10))
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	ping := time.NewTicker(watchPing)
+	defer ping.Stop()
+
+	response := &kvf.Response{Status: kvf.Ok}
+	var buf bytes.Buffer
+	for {
+		signal := kvf.ChangeSignal() // before reading, so a commit during the read is not missed
+		seq := watcher.Seq
+		var events *kvf.Response
+		buf.Reset()
+		err := db.View(func(tx *bolt.Tx) error {
+			events = watcher.Events(r.Context(), tx, &buf)
+			return nil
+		})
+		if err != nil {
+			reqLogger(r).Error("db tx failed", "err", err)
+			events = &kvf.Response{Status: kvf.Fail, Code: kvf.CodeInternal, Msg: "DB Transaction Failed - " + err.Error()}
+		}
+		// written after the tx ends, a client that reads slowly must not keep a read tx open
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			reqLogger(r).Debug("watch write failed", "err", err) // usually the client went away
+			return response, true
+		}
+		response.Count += events.Count
+		if events.Status == kvf.Fail {
+			kvf.WriteWatchError(w, events) // client may be gone, then this fails too
+			rc.Flush()
+			events.Count = response.Count
+			return events, true
+		}
+		if err := rc.Flush(); err != nil {
+			return response, true
+		}
+		if watcher.Seq != seq { // more entries may be waiting
+			continue
+		}
+		select {
+		case <-signal:
+		case <-ping.C:
+			w.Write([]byte(": ping\n\n"))
+			if err := rc.Flush(); err != nil {
+				return response, true
+			}
+		case <-r.Context().Done():
+			if r.Context().Err() == context.DeadlineExceeded { // op time limit, client reconnects from its last id
+				kvf.WriteWatchError(w, &kvf.Response{Status: kvf.Fail, Code: kvf.CodeTimeout, Msg: "Request Time Limit Exceeded", Count: response.Count})
+			}
+			return response, true
+		case <-watchStop:
+			return response, true
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kvfun/kvf"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// blockedWriter is a ResponseWriter whose Write blocks until release is closed, like a stalled subscriber.
+type blockedWriter struct {
+	header  http.Header
+	writing chan struct{} // closed by the 1st Write
+	release chan struct{}
+}
+
+func (bw *blockedWriter) Header() http.Header { return bw.header }
+func (bw *blockedWriter) WriteHeader(int)     {}
+func (bw *blockedWriter) Write(p []byte) (int, error) {
+	select {
+	case <-bw.writing:
+	default:
+		close(bw.writing)
+	}
+	<-bw.release
+	return len(p), nil
+}
+
+// A watch whose client does not read must not hold a read tx, an open read tx blocks the
+// mmap remap of a write tx that grows the db file.
+func TestWatchBlockedWriterDoesNotBlockUpdate(t *testing.T) {
+	defer func(d *bolt.DB, log bool) { db, kvf.ChangeLog = d, log }(db, kvf.ChangeLog)
+	var err error
+	db, err = bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	kvf.ChangeLog = true
+	err = db.Update(func(tx *bolt.Tx) error {
+		return kvf.LogChange(tx, kvf.ChangeBktCreate, "location", nil, nil, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bw := &blockedWriter{header: http.Header{}, writing: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watch(&kvf.WatchRequest{FromSeq: 1}, bw, httptest.NewRequest(http.MethodGet, "/watch", nil))
+	}()
+	select {
+	case <-bw.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not write the change event")
+	}
+
+	updated := make(chan error, 1)
+	go func() {
+		updated <- db.Update(func(tx *bolt.Tx) error { // 16MB, more than the initial mmap, so bolt remaps
+			bkt, err := tx.CreateBucket([]byte("big"))
+			if err != nil {
+				return err
+			}
+			val := bytes.Repeat([]byte("x"), 1<<20)
+			for i := 0; i < 16; i++ {
+				if err := bkt.Put([]byte{byte(i)}, val); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-updated:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("db.Update blocked while the watch client was not reading")
+		defer func() { <-updated }() // completes once the watch ends
+	}
+	close(bw.release)
+	<-done
+}